@@ -0,0 +1,94 @@
+package deploy
+
+import (
+	"sync"
+	"time"
+)
+
+// StatusEvent is a single DeploymentStatus update for a dispatched deploy, timestamped with when
+// the broker observed it.
+type StatusEvent struct {
+	DeployID string
+	Status   DeploymentStatus
+	At       time.Time
+}
+
+// StatusBroker fans out OpenShift watch events for in-flight deploys to subscribers. It
+// coalesces duplicate states so a subscriber isn't woken for a status identical to the last one
+// it was sent, and replays the last known status to clients that subscribe after the event fired.
+type StatusBroker struct {
+	mu          sync.Mutex
+	last        map[string]StatusEvent
+	subscribers map[string]map[chan StatusEvent]struct{}
+}
+
+// NewStatusBroker returns an empty StatusBroker.
+func NewStatusBroker() *StatusBroker {
+	return &StatusBroker{
+		last:        map[string]StatusEvent{},
+		subscribers: map[string]map[chan StatusEvent]struct{}{},
+	}
+}
+
+// Publish records status as the latest DeploymentStatus for deployID and forwards it to every
+// current subscriber, unless it is identical to the status last published for that deploy. A
+// subscriber that isn't keeping up with its channel has the event dropped rather than blocking
+// the publisher.
+func (b *StatusBroker) Publish(deployID string, status DeploymentStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if existing, ok := b.last[deployID]; ok && existing.Status == status {
+		return
+	}
+	event := StatusEvent{DeployID: deployID, Status: status, At: time.Now()}
+	b.last[deployID] = event
+	for sub := range b.subscribers[deployID] {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+}
+
+// Subscription is a handle to a live feed of StatusEvents for a single deploy.
+type Subscription struct {
+	Events <-chan StatusEvent
+	cancel func()
+}
+
+// Close stops the subscription, releasing its channel from the broker.
+func (s *Subscription) Close() {
+	s.cancel()
+}
+
+// Subscribe returns a Subscription for deployID. When replayLast is true and a status has
+// already been published for deployID, it is delivered immediately so a late-joining client
+// doesn't have to wait for the next state change to learn where things stand.
+func (b *StatusBroker) Subscribe(deployID string, replayLast bool) *Subscription {
+	ch := make(chan StatusEvent, 8)
+
+	b.mu.Lock()
+	if b.subscribers[deployID] == nil {
+		b.subscribers[deployID] = map[chan StatusEvent]struct{}{}
+	}
+	b.subscribers[deployID][ch] = struct{}{}
+	last, ok := b.last[deployID]
+	b.mu.Unlock()
+
+	if replayLast && ok {
+		ch <- last
+	}
+
+	return &Subscription{
+		Events: ch,
+		cancel: func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers[deployID], ch)
+			if len(b.subscribers[deployID]) == 0 {
+				delete(b.subscribers, deployID)
+			}
+			close(ch)
+		},
+	}
+}
@@ -0,0 +1,26 @@
+package deploy
+
+import (
+	dc "github.com/openshift/origin/pkg/deploy/api"
+	k8api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/batch"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// Client is the subset of the OpenShift/Kubernetes API this package needs to deploy templates and
+// configure the environment services they depend on. It is implemented by the real OpenShift
+// client the rest of negotiator already constructs; tests can supply a fake.
+type Client interface {
+	GetDeploymentConfigByName(namespace, name string) (*dc.DeploymentConfig, error)
+	UpdateDeployConfigInNamespace(namespace string, deployment *dc.DeploymentConfig) (*dc.DeploymentConfig, error)
+	FindDeploymentConfigsByLabel(namespace string, labels map[string]string) ([]*dc.DeploymentConfig, error)
+	FindServiceByLabel(namespace string, labels map[string]string) ([]*k8api.Service, error)
+	FindJobByName(namespace, name string) (*batch.Job, error)
+	CreateJobToWatch(job *batch.Job, namespace string) (watch.Interface, error)
+	// DeleteJob removes jobName, used both to clear a tombstoned Job before a retry reuses its
+	// name and to clean up after watchConfigJob observes a failed attempt.
+	DeleteJob(namespace, jobName string) error
+	// GetPodLogsByJob returns the logs of jobName's pod, so failJobAndCleanUp can attach them to
+	// the status it publishes when a configuration Job fails.
+	GetPodLogsByJob(namespace, jobName string) (string, error)
+}
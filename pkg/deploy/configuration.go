@@ -1,7 +1,10 @@
 package deploy
 
 import (
+	"database/sql"
 	"math/rand"
+	"net"
+	"net/url"
 	"time"
 
 	"github.com/pkg/errors"
@@ -15,11 +18,11 @@ import (
 	"sync"
 
 	"github.com/feedhenry/negotiator/pkg/log"
+	_ "github.com/go-sql-driver/mysql"
 	dc "github.com/openshift/origin/pkg/deploy/api"
 	k8api "k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/apis/batch"
 	"k8s.io/kubernetes/pkg/runtime"
-	"k8s.io/kubernetes/pkg/watch"
 )
 
 // LogStatusPublisher publishes the status to the log
@@ -47,6 +50,10 @@ type ConfigurationFactory struct {
 	StatusPublisher StatusPublisher
 	TemplateLoader  TemplateLoader
 	Logger          log.Logger
+	// RemoteRegistry, when set, lets Factory hand configuration of a service with no in-tree
+	// Configurer off to an out-of-process ConfigurerDaemon that has registered for it over HTTP
+	// (see pkg/web.RemoteConfigurerHandler).
+	RemoteRegistry *RemoteConfigurerRegistry
 }
 
 // Publisher allows us to set the StatusPublisher for the Configurers
@@ -62,6 +69,7 @@ func (cf *ConfigurationFactory) Factory(service string, config *Configuration, w
 			StatusPublisher: cf.StatusPublisher,
 			statusKey:       StatusKey(config.InstanceID, config.Action),
 			wait:            wait,
+			dryRun:          config.DryRun,
 		}
 	case templateDataMongo:
 		return &DataMongoConfigure{
@@ -70,6 +78,7 @@ func (cf *ConfigurationFactory) Factory(service string, config *Configuration, w
 			logger:          cf.Logger,
 			statusKey:       StatusKey(config.InstanceID, config.Action),
 			wait:            wait,
+			dryRun:          config.DryRun,
 		}
 	case templateDataMysql:
 		return &DataMysqlConfigure{
@@ -78,6 +87,18 @@ func (cf *ConfigurationFactory) Factory(service string, config *Configuration, w
 			logger:          cf.Logger,
 			statusKey:       StatusKey(config.InstanceID, config.Action),
 			wait:            wait,
+			dryRun:          config.DryRun,
+		}
+	}
+
+	if cf.RemoteRegistry != nil && cf.RemoteRegistry.Registered(service) {
+		return &remoteConfigurer{
+			registry:        cf.RemoteRegistry,
+			serviceName:     service,
+			StatusPublisher: cf.StatusPublisher,
+			statusKey:       StatusKey(config.InstanceID, config.Action),
+			wait:            wait,
+			dryRun:          config.DryRun,
 		}
 	}
 
@@ -103,6 +124,23 @@ type Configurer interface {
 	Configure(client Client, deployment *dc.DeploymentConfig, namespace string) (*dc.DeploymentConfig, error)
 }
 
+// Reconciler is implemented by a Configurer that can detect drift between a deployment and the
+// environment service it was configured against, and correct it without re-running the original
+// provisioning Job if the backing DB/user already exists. Not every Configurer needs to support
+// this, so it is a separate interface from Configurer rather than an additional method on it.
+type Reconciler interface {
+	Reconcile(client Client, deployment *dc.DeploymentConfig, namespace string) (changed bool, err error)
+}
+
+// JobPlanner is implemented by a Configurer that builds a configuration Job as part of Configure.
+// When run with dryRun set, such a Configurer stops short of submitting the Job but retains it so
+// Preview can include its templated spec in the returned ConfigurationPlan rather than only the
+// one-line description Configure would otherwise log it as. CacheRedisConfigure never creates a
+// Job, so it does not implement this.
+type JobPlanner interface {
+	PlannedJob() *batch.Job
+}
+
 // EnvironmentServiceConfigController controlls the configuration of environments and services
 type EnvironmentServiceConfigController struct {
 	ConfigurationFactory ServiceConfigFactory
@@ -146,6 +184,10 @@ type Configuration struct {
 	NameSpace      string
 	Action         string
 	InstanceID     string
+	// DryRun, when true, tells Configurers to build the target DeploymentConfig in memory and
+	// report what they would do rather than calling CreateJobToWatch or
+	// UpdateDeployConfigInNamespace. Preview always runs with this set.
+	DryRun bool
 }
 
 // Configure is called to configure the DeploymentConfig of a service that is currently being deployed
@@ -188,9 +230,11 @@ func (cac *EnvironmentServiceConfigController) Configure(client Client, config *
 		}
 	}
 	waitGroup.Wait()
-	if _, err := client.UpdateDeployConfigInNamespace(namespace, deployment); err != nil {
-		cac.StatusPublisher.Publish(statusKey, configError, "failed to update DeployConfig after configuring it")
-		return errors.Wrap(err, "failed to update deployment after configuring it ")
+	if !config.DryRun {
+		if _, err := client.UpdateDeployConfigInNamespace(namespace, deployment); err != nil {
+			cac.StatusPublisher.Publish(statusKey, configError, "failed to update DeployConfig after configuring it")
+			return errors.Wrap(err, "failed to update deployment after configuring it ")
+		}
 	}
 	if len(errs) > 0 {
 		cac.StatusPublisher.Publish(statusKey, configError, fmt.Sprintf(" some configuration jobs failed %v", errs))
@@ -200,11 +244,232 @@ func (cac *EnvironmentServiceConfigController) Configure(client Client, config *
 	return nil
 }
 
+// ConfigurationPlan is the structured diff Preview returns describing what Configure would do to
+// deploymentName's DeploymentConfig without actually doing it.
+type ConfigurationPlan struct {
+	DeploymentName string                      `json:"deploymentName"`
+	Containers     map[string]ContainerEnvDiff `json:"containers,omitempty"`
+	MissingPrereqs []string                    `json:"missingPrerequisites,omitempty"`
+	// Jobs holds the templated spec of every configuration Job a real Configure call would create,
+	// keyed by service name, for Configurers that implement JobPlanner.
+	Jobs map[string]*batch.Job `json:"jobs,omitempty"`
+}
+
+// ContainerEnvDiff is the env var changes Configure would apply to a single container. Values for
+// env vars that look like generated credentials are replaced with a placeholder token so a plan
+// is safe to log or return over the API.
+type ContainerEnvDiff struct {
+	Added   map[string]string `json:"added,omitempty"`
+	Updated map[string]string `json:"updated,omitempty"`
+}
+
+const redactedPlaceholder = "<generated>"
+
+// redactSecret replaces value with a placeholder if name looks like it holds a generated
+// credential or connection string, so Preview never leaks a password it hasn't persisted yet.
+func redactSecret(name, value string) string {
+	upper := strings.ToUpper(name)
+	if strings.Contains(upper, "PASSWORD") || strings.Contains(upper, "CONN_URL") {
+		return redactedPlaceholder
+	}
+	return value
+}
+
+// redactJobEnv returns a copy of job with every container env var that looks like a generated
+// credential replaced by redactedPlaceholder. buildJob templates plaintext values like
+// admin-pass/database-pass straight into the Job it builds, so a planned Job returned from
+// Preview needs the same redaction diffContainerEnv already applies to the container env diff -
+// otherwise ConfigurationPlan.Jobs leaks a password a caller could persist in a log.
+func redactJobEnv(job *batch.Job) *batch.Job {
+	redacted := *job
+	containers := make([]k8api.Container, len(job.Spec.Template.Spec.Containers))
+	copy(containers, job.Spec.Template.Spec.Containers)
+	for ci := range containers {
+		env := make([]k8api.EnvVar, len(containers[ci].Env))
+		copy(env, containers[ci].Env)
+		for ei := range env {
+			env[ei].Value = redactSecret(env[ei].Name, env[ei].Value)
+		}
+		containers[ci].Env = env
+	}
+	redacted.Spec.Template.Spec.Containers = containers
+	return &redacted
+}
+
+// snapshotContainerEnv captures the current env vars of every container in deployment, keyed by
+// container name, so diffContainerEnv can later tell what a Configurer changed.
+func snapshotContainerEnv(deployment *dc.DeploymentConfig) map[string]map[string]string {
+	snapshot := map[string]map[string]string{}
+	for _, c := range deployment.Spec.Template.Spec.Containers {
+		env := map[string]string{}
+		for _, e := range c.Env {
+			env[e.Name] = e.Value
+		}
+		snapshot[c.Name] = env
+	}
+	return snapshot
+}
+
+// diffContainerEnv compares before against deployment's current env vars and returns, per
+// container, the env vars that were added or changed.
+func diffContainerEnv(before map[string]map[string]string, deployment *dc.DeploymentConfig) map[string]ContainerEnvDiff {
+	diffs := map[string]ContainerEnvDiff{}
+	for _, c := range deployment.Spec.Template.Spec.Containers {
+		beforeEnv := before[c.Name]
+		added := map[string]string{}
+		updated := map[string]string{}
+		for _, e := range c.Env {
+			prev, existed := beforeEnv[e.Name]
+			if !existed {
+				added[e.Name] = redactSecret(e.Name, e.Value)
+			} else if prev != e.Value {
+				updated[e.Name] = redactSecret(e.Name, e.Value)
+			}
+		}
+		if len(added) > 0 || len(updated) > 0 {
+			diffs[c.Name] = ContainerEnvDiff{Added: added, Updated: updated}
+		}
+	}
+	return diffs
+}
+
+// Preview runs the same configuration pass as Configure, forcing DryRun so no Job is created and
+// deployment's DeploymentConfig is never persisted, and returns what would have changed as a
+// ConfigurationPlan instead. A Configurer returning an error (for instance a missing data-mongo
+// DeploymentConfig) is recorded under MissingPrereqs rather than aborting the whole preview, so a
+// single missing prerequisite doesn't hide what the other services would have done.
+func (cac *EnvironmentServiceConfigController) Preview(client Client, config *Configuration) (*ConfigurationPlan, error) {
+	previewConfig := *config
+	previewConfig.DryRun = true
+
+	deploymentName := previewConfig.DeploymentName
+	namespace := previewConfig.NameSpace
+	deployment, err := client.GetDeploymentConfigByName(namespace, deploymentName)
+	if err != nil {
+		return nil, errors.Wrap(err, "unexpected error retrieving DeployConfig for deployment "+deploymentName)
+	}
+	if deployment == nil {
+		return nil, errors.New("could not find DeploymentConfig for " + deploymentName)
+	}
+	before := snapshotContainerEnv(deployment)
+
+	services, err := client.FindDeploymentConfigsByLabel(namespace, map[string]string{"rhmap/type": "environmentService"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve environment Service dcs during preview of "+deployment.Name)
+	}
+
+	var missing []string
+	jobs := map[string]*batch.Job{}
+	waitGroup := &sync.WaitGroup{}
+	configured := map[string]bool{}
+	for _, s := range services {
+		serviceName := s.Labels["rhmap/name"]
+		if _, ok := configured[serviceName]; ok {
+			continue
+		}
+		configured[serviceName] = true
+		c := cac.ConfigurationFactory.Factory(serviceName, &previewConfig, waitGroup)
+		if _, err := c.Configure(client, deployment, namespace); err != nil {
+			missing = append(missing, serviceName+": "+err.Error())
+		}
+		if planner, ok := c.(JobPlanner); ok {
+			if job := planner.PlannedJob(); job != nil {
+				jobs[serviceName] = redactJobEnv(job)
+			}
+		}
+	}
+	waitGroup.Wait()
+
+	return &ConfigurationPlan{
+		DeploymentName: deploymentName,
+		Containers:     diffContainerEnv(before, deployment),
+		MissingPrereqs: missing,
+		Jobs:           jobs,
+	}, nil
+}
+
+// Reconcile re-checks deploymentName's configuration against every environment service deployed
+// in config.NameSpace, via each Configurer's Reconcile method where it implements Reconciler, and
+// only calls UpdateDeployConfigInNamespace if something had actually drifted. It is intended to
+// be called periodically (see pkg/deploy/reconciler) rather than only once at provision time, so
+// that env vars changed outside of negotiator are corrected. Results are published under
+// StatusKey(config.InstanceID, "reconcile") rather than config.Action, so drift correction shows
+// up as its own history distinct from the original provision/update/deprovision operation.
+func (cac *EnvironmentServiceConfigController) Reconcile(client Client, config *Configuration) error {
+	deploymentName := config.DeploymentName
+	namespace := config.NameSpace
+	statusKey := StatusKey(config.InstanceID, "reconcile")
+
+	deployment, err := client.GetDeploymentConfigByName(namespace, deploymentName)
+	if err != nil {
+		return errors.Wrap(err, "unexpected error retrieving DeployConfig for deployment "+deploymentName)
+	}
+	if deployment == nil {
+		return errors.New("could not find DeploymentConfig for " + deploymentName)
+	}
+
+	services, err := client.FindDeploymentConfigsByLabel(namespace, map[string]string{"rhmap/type": "environmentService"})
+	if err != nil {
+		cac.StatusPublisher.Publish(statusKey, configError, "failed to retrieve environment Service dcs during reconcile of "+deployment.Name+" "+err.Error())
+		return err
+	}
+
+	errs := []string{}
+	changedAny := false
+	reconciled := map[string]bool{}
+	waitGroup := &sync.WaitGroup{}
+	for _, s := range services {
+		serviceName := s.Labels["rhmap/name"]
+		if _, ok := reconciled[serviceName]; ok {
+			continue
+		}
+		reconciled[serviceName] = true
+
+		c := cac.ConfigurationFactory.Factory(serviceName, config, waitGroup)
+		reconciler, ok := c.(Reconciler)
+		if !ok {
+			continue
+		}
+		changed, err := reconciler.Reconcile(client, deployment, namespace)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if changed {
+			changedAny = true
+		}
+	}
+
+	if !changedAny {
+		if len(errs) > 0 {
+			cac.StatusPublisher.Publish(statusKey, configError, fmt.Sprintf("some reconcile checks failed %v", errs))
+			return errors.New(fmt.Sprintf("some reconcile checks failed %v", errs))
+		}
+		cac.StatusPublisher.Publish(statusKey, configComplete, "no drift detected")
+		return nil
+	}
+
+	if _, err := client.UpdateDeployConfigInNamespace(namespace, deployment); err != nil {
+		cac.StatusPublisher.Publish(statusKey, configError, "failed to update DeployConfig after reconciling it")
+		return errors.Wrap(err, "failed to update deployment after reconciling it ")
+	}
+	if len(errs) > 0 {
+		cac.StatusPublisher.Publish(statusKey, configError, fmt.Sprintf("some reconcile checks failed %v", errs))
+		return errors.New(fmt.Sprintf("some reconcile checks failed %v", errs))
+	}
+	cac.StatusPublisher.Publish(statusKey, configComplete, "drift corrected")
+	return nil
+}
+
 // CacheRedisConfigure is a Configurer for the cache service
 type CacheRedisConfigure struct {
 	StatusPublisher StatusPublisher
 	statusKey       string
 	wait            *sync.WaitGroup
+	// dryRun is set by Factory from Configuration.DryRun. CacheRedisConfigure never creates a Job
+	// or calls UpdateDeployConfigInNamespace itself, so it has no behavior to gate, but the field
+	// is kept for symmetry with DataMongoConfigure/DataMysqlConfigure.
+	dryRun bool
 }
 
 // Configure configures the current DeploymentConfig with the need configuration to use cache
@@ -232,6 +497,27 @@ func (c *CacheRedisConfigure) Configure(client Client, deployment *dc.Deployment
 	return deployment, nil
 }
 
+// Reconcile re-checks that FH_REDIS_HOST still points at data-cache, correcting it if something
+// has changed it since Configure last ran. There is no provisioning Job to skip re-running here,
+// so this simply repeats the env var check and reports whether it had to change anything.
+func (c *CacheRedisConfigure) Reconcile(client Client, deployment *dc.DeploymentConfig, namespace string) (bool, error) {
+	if v, ok := deployment.Labels["rhmap/name"]; ok && v == "cache" {
+		return false, nil
+	}
+	changed := false
+	for ci := range deployment.Spec.Template.Spec.Containers {
+		env := deployment.Spec.Template.Spec.Containers[ci].Env
+		for ei, e := range env {
+			if e.Name == "FH_REDIS_HOST" && e.Value != "data-cache" {
+				deployment.Spec.Template.Spec.Containers[ci].Env[ei].Value = "data-cache"
+				changed = true
+				break
+			}
+		}
+	}
+	return changed, nil
+}
+
 // DataMongoConfigure is a object for configuring mongo connection strings
 type DataMongoConfigure struct {
 	StatusPublisher StatusPublisher
@@ -240,6 +526,21 @@ type DataMongoConfigure struct {
 	status          *Status
 	logger          log.Logger
 	wait            *sync.WaitGroup
+	// dryRun is set by Factory from Configuration.DryRun. When true, Configure builds the
+	// DeploymentConfig and Job spec in memory but stops short of CreateJobToWatch.
+	dryRun bool
+	// RetryPolicy controls how many times a failed configuration Job is resubmitted. The zero
+	// value defers to DefaultRetryPolicy.
+	RetryPolicy RetryPolicy
+	// plannedJob is the Job buildJob produced on the most recent dryRun Configure call, retained
+	// for PlannedJob so Preview can include its templated spec in the ConfigurationPlan.
+	plannedJob *batch.Job
+}
+
+// PlannedJob returns the Job a dryRun Configure call most recently built, or nil if Configure
+// hasn't run in dryRun mode.
+func (d *DataMongoConfigure) PlannedJob() *batch.Job {
+	return d.plannedJob
 }
 
 // DataMysqlConfigure is a object for configuring mysql connection variables
@@ -250,6 +551,21 @@ type DataMysqlConfigure struct {
 	status          *Status
 	logger          log.Logger
 	wait            *sync.WaitGroup
+	// dryRun is set by Factory from Configuration.DryRun. When true, Configure builds the
+	// DeploymentConfig and Job spec in memory but stops short of CreateJobToWatch.
+	dryRun bool
+	// RetryPolicy controls how many times a failed configuration Job is resubmitted. The zero
+	// value defers to DefaultRetryPolicy.
+	RetryPolicy RetryPolicy
+	// plannedJob is the Job buildJob produced on the most recent dryRun Configure call, retained
+	// for PlannedJob so Preview can include its templated spec in the ConfigurationPlan.
+	plannedJob *batch.Job
+}
+
+// PlannedJob returns the Job a dryRun Configure call most recently built, or nil if Configure
+// hasn't run in dryRun mode.
+func (d *DataMysqlConfigure) PlannedJob() *batch.Job {
+	return d.plannedJob
 }
 
 func (d *DataMongoConfigure) statusUpdate(description, status string) {
@@ -277,15 +593,24 @@ func (d *DataMongoConfigure) Configure(client Client, deployment *dc.DeploymentC
 		}
 		return url
 	}
-	// look for the Job if it already exists no need to run it again
+	// look for the Job: if it already completed there is no need to run it again. Any other Job
+	// (failed, timed out or still running) is deleted so a new attempt can reuse the Job name.
 	existingJob, err := client.FindJobByName(namespace, deployment.Name+"-dataconfig-job")
 	if err != nil {
 		d.statusUpdate("error finding existing Job "+err.Error(), "error")
 		return deployment, nil
 	}
+	startAttempt := 0
 	if existingJob != nil {
-		d.statusUpdate("configuration job "+deployment.Name+"-dataconfig-job already exists. No need to run again ", "complete")
-		return deployment, nil
+		if jobSucceeded(existingJob) {
+			d.statusUpdate("configuration job "+deployment.Name+"-dataconfig-job already completed. No need to run again ", "complete")
+			return deployment, nil
+		}
+		startAttempt = jobAttempt(existingJob) + 1
+		if err := client.DeleteJob(namespace, existingJob.GetName()); err != nil {
+			d.statusUpdate("failed to delete unfinished configuration job "+err.Error(), configError)
+			return nil, err
+		}
 	}
 	dataDc, err := client.FindDeploymentConfigsByLabel(namespace, map[string]string{"rhmap/name": esName})
 	if err != nil {
@@ -359,57 +684,100 @@ func (d *DataMongoConfigure) Configure(client Client, deployment *dc.DeploymentC
 		d.statusUpdate("failed to load job template "+err.Error(), configError)
 		return nil, errors.Wrap(err, "failed to load template data-mongo-job ")
 	}
-	var buf bytes.Buffer
-	if err := tpl.ExecuteTemplate(&buf, "data-mongo-job", jobOpts); err != nil {
-		err = errors.Wrap(err, "failed to execute template: ")
-		d.statusUpdate(err.Error(), configError)
-		return nil, err
-	}
-	j := &batch.Job{}
-	if err := runtime.DecodeInto(k8api.Codecs.UniversalDecoder(), buf.Bytes(), j); err != nil {
-		err = errors.Wrap(err, "failed to Decode job")
-		d.statusUpdate(err.Error(), "error")
-		return nil, err
+	buildJob := func(attempt int) (*batch.Job, error) {
+		jobOpts["attempt"] = attempt
+		var buf bytes.Buffer
+		if err := tpl.ExecuteTemplate(&buf, "data-mongo-job", jobOpts); err != nil {
+			return nil, errors.Wrap(err, "failed to execute template")
+		}
+		j := &batch.Job{}
+		if err := runtime.DecodeInto(k8api.Codecs.UniversalDecoder(), buf.Bytes(), j); err != nil {
+			return nil, errors.Wrap(err, "failed to Decode job")
+		}
+		return j, nil
 	}
-	w, err := client.CreateJobToWatch(j, namespace)
-	if err != nil {
-		d.statusUpdate("failed to CreateJobToWatch "+err.Error(), configError)
-		return nil, err
+	if d.dryRun {
+		j, err := buildJob(startAttempt)
+		if err != nil {
+			d.statusUpdate(err.Error(), configError)
+			return nil, err
+		}
+		d.plannedJob = j
+		d.statusUpdate("dry run: would create job "+j.GetName()+" and set "+"FH_MONGODB_CONN_URL", configComplete)
+		return deployment, nil
 	}
-	//set off job and watch it till complete
+	//set off job and watch it till complete, retrying on failure per d.RetryPolicy
 	go func() {
 		d.wait.Add(1)
 		defer d.wait.Done()
-		result := w.ResultChan()
-		for ws := range result {
-			switch ws.Type {
-			case watch.Added, watch.Modified:
-				j := ws.Object.(*batch.Job)
-				// succeeded will always be 1 if a deadline is reached
-				if j.Status.Succeeded >= 1 {
-					w.Stop()
-					for _, condition := range j.Status.Conditions {
-						if condition.Reason == "DeadlineExceeded" && condition.Type == "Failed" {
-							d.statusUpdate("configuration job  timed out and failed to configure database  "+condition.Message, configError)
-							//TODO Maybe we should delete the job a this point to allow it to be retried.
-						} else if condition.Type == "Complete" {
-							d.statusUpdate("configuration job succeeded ", configInProgress)
-						}
-					}
-				}
-				d.statusUpdate(fmt.Sprintf("job status succeeded %d failed %d", j.Status.Succeeded, j.Status.Failed), configInProgress)
-			case watch.Error:
-				d.statusUpdate(" data-mongo configuration job error ", configError)
-				//TODO maybe pull back the log from the pod here? also remove the job in this condition so it can be retried
-				w.Stop()
-			}
-
-		}
+		runConfigJobWithRetry(client, namespace, d.RetryPolicy, startAttempt, d.statusUpdate, buildJob, nil)
 	}()
 
 	return deployment, nil
 }
 
+// Reconcile corrects FH_MONGODB_CONN_URL if the data-mongo service it points at has drifted,
+// without creating a new configuration Job - the dataconfig Job having already completed is
+// exactly the "DB/user already exists" signal that means Reconcile should not re-provision. The
+// credentials and database Configure originally generated aren't recoverable here (they aren't
+// persisted anywhere this Configurer can read), so Reconcile can only correct the host portion of
+// the URL, not regenerate it outright.
+func (d *DataMongoConfigure) Reconcile(client Client, deployment *dc.DeploymentConfig, namespace string) (bool, error) {
+	esName := "data-mongo"
+	if v, ok := deployment.Labels["rhmap/name"]; ok && v == esName {
+		return false, nil
+	}
+
+	existingJob, err := client.FindJobByName(namespace, deployment.Name+"-dataconfig-job")
+	if err != nil {
+		return false, errors.Wrap(err, "failed to find existing Job")
+	}
+	if existingJob == nil || !jobSucceeded(existingJob) {
+		// never successfully configured; Configure runs (or retries) the provisioning
+		return false, nil
+	}
+
+	dataService, err := client.FindServiceByLabel(namespace, map[string]string{"rhmap/name": esName})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to find data-mongo service")
+	}
+	if len(dataService) == 0 {
+		return false, errors.New("no service for data-mongo found. Cannot reconcile")
+	}
+	host := dataService[0].GetName()
+
+	changed := false
+	for ci := range deployment.Spec.Template.Spec.Containers {
+		env := deployment.Spec.Template.Spec.Containers[ci].Env
+		for ei, e := range env {
+			if e.Name != "FH_MONGODB_CONN_URL" {
+				continue
+			}
+			updated, driftCorrected := withMongoHost(e.Value, host)
+			if driftCorrected {
+				deployment.Spec.Template.Spec.Containers[ci].Env[ei].Value = updated
+				changed = true
+			}
+		}
+	}
+	return changed, nil
+}
+
+// withMongoHost rewrites the host of a mongodb:// connection URL to host, preserving the
+// credentials, database and query string, and reports whether anything changed.
+func withMongoHost(connURL, host string) (string, bool) {
+	u, err := url.Parse(connURL)
+	if err != nil || u.Host == "" || u.Hostname() == host {
+		return connURL, false
+	}
+	newHost := host
+	if _, port, err := net.SplitHostPort(u.Host); err == nil && port != "" {
+		newHost = host + ":" + port
+	}
+	u.Host = newHost
+	return u.String(), true
+}
+
 func (d *DataMysqlConfigure) statusUpdate(description, status string) {
 	if err := d.StatusPublisher.Publish(d.statusKey, status, description); err != nil {
 		d.logger.Error("failed to publish status", err.Error())
@@ -437,15 +805,24 @@ func (d *DataMysqlConfigure) Configure(client Client, deployment *dc.DeploymentC
 		d.statusUpdate(err.Error(), configError)
 		return nil, err
 	}
-	// look for the Job if it already exists no need to run it again
+	// look for the Job: if it already completed there is no need to run it again. Any other Job
+	// (failed, timed out or still running) is deleted so a new attempt can reuse the Job name.
 	existingJob, err := client.FindJobByName(namespace, deployment.Name+"-dataconfig-job")
 	if err != nil {
 		d.statusUpdate("error finding existing Job "+err.Error(), "error")
 		return deployment, nil
 	}
+	startAttempt := 0
 	if existingJob != nil {
-		d.statusUpdate("configuration job "+deployment.Name+"-dataconfig-job already exists. No need to run again ", "complete")
-		return deployment, nil
+		if jobSucceeded(existingJob) {
+			d.statusUpdate("configuration job "+deployment.Name+"-dataconfig-job already completed. No need to run again ", "complete")
+			return deployment, nil
+		}
+		startAttempt = jobAttempt(existingJob) + 1
+		if err := client.DeleteJob(namespace, existingJob.GetName()); err != nil {
+			d.statusUpdate("failed to delete unfinished configuration job "+err.Error(), configError)
+			return nil, err
+		}
 	}
 	dataService, err := client.FindServiceByLabel(namespace, map[string]string{"rhmap/name": templateDataMysql})
 	if err != nil {
@@ -457,6 +834,31 @@ func (d *DataMysqlConfigure) Configure(client Client, deployment *dc.DeploymentC
 		d.statusUpdate(err.Error(), configError)
 		return nil, err
 	}
+
+	// a replicated topology is labeled rhmap/role=master/slave on top of rhmap/name=data-mysql,
+	// mirroring how DataMongoConfigure detects a replica set via MONGODB_REPLICA_NAME. Fall back
+	// to today's single-node behavior (dataService[0]) when no slave is present.
+	masterServices, err := client.FindServiceByLabel(namespace, map[string]string{"rhmap/name": templateDataMysql, "rhmap/role": "master"})
+	if err != nil {
+		d.statusUpdate("failed to find master data-mysql service "+err.Error(), configError)
+		return nil, err
+	}
+	slaveServices, err := client.FindServiceByLabel(namespace, map[string]string{"rhmap/name": templateDataMysql, "rhmap/role": "slave"})
+	if err != nil {
+		d.statusUpdate("failed to find slave data-mysql services "+err.Error(), configError)
+		return nil, err
+	}
+	replicated := len(masterServices) > 0 && len(slaveServices) > 0
+
+	dbHost := dataService[0].GetName()
+	readHosts := []string{}
+	if replicated {
+		dbHost = masterServices[0].GetName()
+		for _, s := range slaveServices {
+			readHosts = append(readHosts, s.GetName())
+		}
+	}
+
 	jobOpts := map[string]interface{}{}
 
 	containerEnv := dataDc[0].Spec.Template.Spec.Containers[0].Env
@@ -477,11 +879,18 @@ func (d *DataMysqlConfigure) Configure(client Client, deployment *dc.DeploymentC
 
 	jobName := "data-mysql-job"
 	jobOpts["name"] = deployment.Name
-	jobOpts["dbhost"] = dataService[0].GetName()
+	// the user-provisioning job only ever runs against the master; replication carries the new
+	// user/grants to the slaves
+	jobOpts["dbhost"] = dbHost
 
 	jobOpts["admin-username"] = "root"
 	jobOpts["admin-database"] = "mysql"
 
+	if replicated {
+		jobOpts["wait-for-replication"] = true
+		jobOpts["replica-hosts"] = strings.Join(readHosts, ",")
+	}
+
 	if v, ok := deployment.Labels["rhmap/guid"]; ok {
 		if v == "" {
 			// this is unique to the environment
@@ -497,18 +906,23 @@ func (d *DataMysqlConfigure) Configure(client Client, deployment *dc.DeploymentC
 	if len(databaseUser) > 16 {
 		jobOpts["user-username"] = databaseUser[:16]
 	}
+	envValues := map[string]string{
+		"MYSQL_USER":       jobOpts["user-username"].(string),
+		"MYSQL_PASSWORD":   jobOpts["user-password"].(string),
+		"MYSQL_DATABASE":   jobOpts["user-database"].(string),
+		"MYSQL_HOST":       dbHost,
+		"MYSQL_WRITE_HOST": dbHost,
+	}
+	if replicated {
+		envValues["MYSQL_READ_HOSTS"] = strings.Join(readHosts, ",")
+	}
 	for ci := range deployment.Spec.Template.Spec.Containers {
 		env := deployment.Spec.Template.Spec.Containers[ci].Env
-		envFromOpts := map[string]string{
-			"MYSQL_USER":     "user-username",
-			"MYSQL_PASSWORD": "user-password",
-			"MYSQL_DATABASE": "user-database",
-		}
-		for envName, optsName := range envFromOpts {
+		for envName, value := range envValues {
 			found := false
 			for ei, e := range env {
 				if e.Name == envName {
-					deployment.Spec.Template.Spec.Containers[ci].Env[ei].Value = jobOpts[optsName].(string)
+					deployment.Spec.Template.Spec.Containers[ci].Env[ei].Value = value
 					found = true
 					break
 				}
@@ -516,7 +930,7 @@ func (d *DataMysqlConfigure) Configure(client Client, deployment *dc.DeploymentC
 			if !found {
 				deployment.Spec.Template.Spec.Containers[ci].Env = append(deployment.Spec.Template.Spec.Containers[ci].Env, k8api.EnvVar{
 					Name:  envName,
-					Value: jobOpts[optsName].(string),
+					Value: value,
 				})
 			}
 		}
@@ -527,50 +941,131 @@ func (d *DataMysqlConfigure) Configure(client Client, deployment *dc.DeploymentC
 		d.statusUpdate("failed to load job template "+err.Error(), configError)
 		return nil, errors.Wrap(err, "failed to load template "+jobName)
 	}
-	var buf bytes.Buffer
-	if err := tpl.ExecuteTemplate(&buf, jobName, jobOpts); err != nil {
-		err = errors.Wrap(err, "failed to execute template: ")
-		d.statusUpdate(err.Error(), configError)
-		return nil, err
+	buildJob := func(attempt int) (*batch.Job, error) {
+		jobOpts["attempt"] = attempt
+		var buf bytes.Buffer
+		if err := tpl.ExecuteTemplate(&buf, jobName, jobOpts); err != nil {
+			return nil, errors.Wrap(err, "failed to execute template")
+		}
+		j := &batch.Job{}
+		if err := runtime.DecodeInto(k8api.Codecs.UniversalDecoder(), buf.Bytes(), j); err != nil {
+			return nil, errors.Wrap(err, "failed to Decode job")
+		}
+		return j, nil
 	}
-	j := &batch.Job{}
-	if err := runtime.DecodeInto(k8api.Codecs.UniversalDecoder(), buf.Bytes(), j); err != nil {
-		err = errors.Wrap(err, "failed to Decode job")
-		d.statusUpdate(err.Error(), "error")
-		return nil, err
+	if d.dryRun {
+		j, err := buildJob(startAttempt)
+		if err != nil {
+			d.statusUpdate(err.Error(), configError)
+			return nil, err
+		}
+		d.plannedJob = j
+		d.statusUpdate("dry run: would create job "+j.GetName()+" and set MYSQL_* env vars", configComplete)
+		return deployment, nil
+	}
+	//set off job and watch it till complete, retrying on failure per d.RetryPolicy. In a replicated
+	// topology the new user only exists on the master until replication catches up, so hold
+	// configComplete back with waitForReplication rather than reporting done the moment the job
+	// that created the user on the master succeeds.
+	var onSuccess func()
+	if replicated {
+		user := jobOpts["user-username"].(string)
+		onSuccess = func() {
+			d.waitForReplication(jobOpts["admin-username"].(string), jobOpts["admin-password"].(string), user, readHosts)
+		}
 	}
-	//set off job and watch it till complete
 	go func() {
 		d.wait.Add(1)
 		defer d.wait.Done()
-		w, err := client.CreateJobToWatch(j, namespace)
-		if err != nil {
-			d.statusUpdate("failed to CreateJobToWatch "+err.Error(), configError)
-			return
-		}
-		result := w.ResultChan()
-		for ws := range result {
-			switch ws.Type {
-			case watch.Added, watch.Modified:
-				j := ws.Object.(*batch.Job)
-				if j.Status.Succeeded >= 1 {
-					d.statusUpdate("configuration job succeeded ", configInProgress)
-					w.Stop()
-				}
-				d.statusUpdate(fmt.Sprintf("job status succeeded %d failed %d", j.Status.Succeeded, j.Status.Failed), configInProgress)
-				for _, condition := range j.Status.Conditions {
-					if condition.Reason == "DeadlineExceeded" {
-						d.statusUpdate("configuration job failed to configure database in time "+condition.Message, configError)
-						w.Stop()
-					}
-				}
-			case watch.Error:
-				d.statusUpdate(" data configuration job error ", configError)
-				w.Stop()
-			}
-
-		}
+		runConfigJobWithRetry(client, namespace, d.RetryPolicy, startAttempt, d.statusUpdate, buildJob, onSuccess)
 	}()
 
 	return deployment, nil
 }
+
+// waitForReplication polls each replica in readHosts until user shows up in its mysql.user table,
+// so DataMysqlConfigure.Configure doesn't report configComplete while a replica still lacks the
+// grants the provisioning Job just created on the master. It queries mysql.user directly and does
+// not read SHOW SLAVE STATUS. It gives up and reports configError once replicationWaitDeadline has
+// passed, the same way DependencyWaiter gives up on a dependency that never reaches a terminal
+// state, rather than blocking forever.
+func (d *DataMysqlConfigure) waitForReplication(adminUser, adminPassword, user string, readHosts []string) {
+	deadline := time.Now().Add(replicationWaitDeadline)
+	for _, host := range readHosts {
+		d.statusUpdate("waiting for user "+user+" to replicate to "+host, configInProgress)
+		for attempt := 0; ; attempt++ {
+			caughtUp, err := replicaHasUser(host, adminUser, adminPassword, user)
+			if err != nil {
+				d.statusUpdate("failed to check replication status on "+host+": "+err.Error(), configError)
+				return
+			}
+			if caughtUp {
+				break
+			}
+			if time.Now().After(deadline) {
+				d.statusUpdate("timed out waiting for user "+user+" to replicate to "+host, configError)
+				return
+			}
+			time.Sleep(backoffDelay(attempt))
+		}
+	}
+	d.statusUpdate("replication caught up on all replicas for user "+user, configComplete)
+}
+
+// replicationWaitDeadline bounds how long waitForReplication polls, in total across all replicas,
+// before giving up.
+const replicationWaitDeadline = 5 * time.Minute
+
+// replicaHasUser opens a connection to host as adminUser and reports whether user already exists
+// in mysql.user - the signal that replication of the provisioning Job's CREATE USER has caught up.
+var replicaHasUser = func(host, adminUser, adminPassword, user string) (bool, error) {
+	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:3306)/mysql", adminUser, adminPassword, host))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open connection to replica "+host)
+	}
+	defer db.Close()
+
+	var found int
+	err = db.QueryRow("SELECT COUNT(*) FROM mysql.user WHERE user = ?", user).Scan(&found)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to query mysql.user on replica "+host)
+	}
+	return found > 0, nil
+}
+
+// Reconcile checks that deployment's MYSQL_USER, MYSQL_PASSWORD and MYSQL_DATABASE env vars are
+// still present, without creating a new configuration Job when the user-provisioning Job has
+// already completed. The generated password isn't recoverable here, so a missing env var can be
+// detected but not regenerated; it is reported as an error rather than silently left broken. A
+// changed value is not detected, and Reconcile never reports a change.
+func (d *DataMysqlConfigure) Reconcile(client Client, deployment *dc.DeploymentConfig, namespace string) (bool, error) {
+	if v, ok := deployment.Labels["rhmap/name"]; ok && v == templateDataMysql {
+		return false, nil
+	}
+
+	existingJob, err := client.FindJobByName(namespace, deployment.Name+"-dataconfig-job")
+	if err != nil {
+		return false, errors.Wrap(err, "failed to find existing Job")
+	}
+	if existingJob == nil || !jobSucceeded(existingJob) {
+		// never successfully configured; Configure runs (or retries) the provisioning
+		return false, nil
+	}
+
+	for ci := range deployment.Spec.Template.Spec.Containers {
+		env := deployment.Spec.Template.Spec.Containers[ci].Env
+		for _, required := range []string{"MYSQL_USER", "MYSQL_PASSWORD", "MYSQL_DATABASE"} {
+			found := false
+			for _, e := range env {
+				if e.Name == required {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false, fmt.Errorf("%s is missing from deployment %s and cannot be regenerated without re-provisioning", required, deployment.Name)
+			}
+		}
+	}
+	return false, nil
+}
@@ -1,13 +1,18 @@
 package deploy
 
 import (
+	"context"
 	"crypto/tls"
-	"errors"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 //Deployer interface for the Deploy Controller
@@ -15,78 +20,340 @@ type Deployer interface {
 	Template(Client, string, string, *Payload) (*Dispatched, error)
 }
 
-func deployDependencyServices(c Deployer, client Client, template *Template, nameSpace string, payload *Payload) ([]*Dispatched, error) {
+func deployDependencyServices(c Deployer, client Client, template *Template, nameSpace string, payload *Payload, broker *StatusBroker, inFlight *sync.WaitGroup) (dependencies []*Dispatched, err error) {
+	// times and records the outcome of the whole dependency fan-out for this template under
+	// deployDuration/templateDeploysTotal, covering both the flat-list and graph code paths below.
+	start := time.Now()
+	defer func() {
+		outcome := "succeeded"
+		if err != nil {
+			outcome = "failed"
+		}
+		observeDeployOutcome(template.Name, nameSpace, outcome, time.Since(start).Seconds())
+	}()
+
+	// a "dependencies.yaml" annotation carries a full DependencyGraph (ordering, parameter
+	// overrides, readiness gates); fall back to the older flat "dependencies" annotation when
+	// it isn't present.
+	if graphDoc, ok := template.Annotations["dependencies.yaml"]; ok {
+		dependencies, err = deployDependencyGraph(c, client, nameSpace, payload, []byte(graphDoc), broker, inFlight)
+		return dependencies, err
+	}
+
 	if _, ok := template.Annotations["dependencies"]; !ok {
 		// no dependencies to process
 		return nil, nil
 	}
 
-	dependencies := []*Dispatched{}
-
+	depNames := map[*Dispatched]string{}
+	var dispatchErr error
 	for _, dep := range strings.Split(template.Annotations["dependencies"], " ") {
-		dispatched, err := c.Template(client, dep, nameSpace, payload)
-		if err != nil {
-			return dependencies, err
+		dispatched, derr := c.Template(client, dep, nameSpace, payload)
+		if derr != nil {
+			templateDeploysTotal.WithLabelValues(dep, nameSpace, "dispatch_error").Inc()
+			dispatchErr = derr
+			break
 		}
+		depNames[dispatched] = dep
 		dependencies = append(dependencies, dispatched)
 	}
 
+	// wait on whatever was successfully dispatched even if a later dependency failed to dispatch,
+	// so an earlier one's real outcome is still recorded rather than silently dropped.
+	waiter := NewDependencyWaiter(&tls.Config{InsecureSkipVerify: true}, 5*time.Minute)
+	waiter.Broker = broker
+	waiter.InFlight = inFlight
+	results := waiter.waitAll(context.Background(), nameSpace, payload, dependencies)
+
+	var errs []string
+	if dispatchErr != nil {
+		errs = append(errs, dispatchErr.Error())
+	}
+	for _, result := range results {
+		outcome, errMsg := result.describe()
+		if errMsg != "" {
+			errs = append(errs, errMsg)
+		}
+		templateDeploysTotal.WithLabelValues(depNames[result.dependency], nameSpace, outcome).Inc()
+	}
+	if len(errs) > 0 {
+		err = errors.New(strings.Join(errs, "\n"))
+		return dependencies, err
+	}
 	return dependencies, nil
 }
 
-func waitForDependencies(client Client, namespace string, dependencies []*Dispatched, payload *Payload) error {
-	var dependencyGroup sync.WaitGroup
-	depErrors := []string{}
+// deployDependencyGraph parses doc as a DependencyGraph and deploys it with a GraphScheduler,
+// fanning independent nodes out concurrently and blocking dependents on their parents reaching
+// Ready. When broker is non-nil every node's observed status is published to it, keyed by the
+// node's own dispatched deployment name, so a caller can watch an individual dependency the same
+// way it watches the top-level deploy. When inFlight is non-nil it is incremented/decremented
+// around each node's readiness wait, so a GracefulServer can hold shutdown open until they drain.
+func deployDependencyGraph(c Deployer, client Client, nameSpace string, payload *Payload, doc []byte, broker *StatusBroker, inFlight *sync.WaitGroup) ([]*Dispatched, error) {
+	graph, err := ParseDependencyGraph(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse dependency graph")
+	}
+
+	waiter := NewDependencyWaiter(&tls.Config{InsecureSkipVerify: true}, 5*time.Minute)
+	waiter.Broker = broker
+	waiter.InFlight = inFlight
+	scheduler := &GraphScheduler{
+		Deployer: c,
+		Waiter:   waiter,
+	}
+	// bound the whole graph run by the waiter's deadline - without this, a node whose readiness
+	// probe or watch endpoint never reaches a terminal state hangs Run (and the wg.Wait() inside
+	// it) forever, since nodeReady polls with ctx directly instead of going through
+	// DependencyWaiter.Wait's own context.WithTimeout wrapping.
+	ctx, cancel := context.WithTimeout(context.Background(), scheduler.Waiter.Deadline)
+	defer cancel()
+	result := scheduler.Run(ctx, client, nameSpace, graph, payload)
+
+	dependencies := []*Dispatched{}
+	var errs []string
+	for name, node := range result.Nodes {
+		if node.Dispatched != nil {
+			dependencies = append(dependencies, node.Dispatched)
+		}
+		outcome, errMsg := describeOutcome(name, node.Status, node.Err)
+		if errMsg != "" {
+			errs = append(errs, errMsg)
+		}
+		templateDeploysTotal.WithLabelValues(name, nameSpace, outcome).Inc()
+	}
+	if len(errs) > 0 {
+		return dependencies, errors.New(strings.Join(errs, "\n"))
+	}
+	return dependencies, nil
+}
+
+// DeploymentState is the lifecycle stage reported by a dependency's watch endpoint.
+type DeploymentState string
+
+// The states a dependency can be in while it is being brought up.
+const (
+	DeploymentPending   DeploymentState = "Pending"
+	DeploymentRunning   DeploymentState = "Running"
+	DeploymentSucceeded DeploymentState = "Succeeded"
+	DeploymentFailed    DeploymentState = "Failed"
+)
+
+// terminal reports whether the state is one a DependencyWaiter should stop polling on.
+func (s DeploymentState) terminal() bool {
+	return s == DeploymentSucceeded || s == DeploymentFailed
+}
+
+// DeploymentStatus is the typed representation of a dependency's watch response, replacing the
+// previous substring match against the raw response body.
+type DeploymentStatus struct {
+	State       DeploymentState `json:"state"`
+	Description string          `json:"description"`
+}
+
+// backoffSchedule is the sequence of delays tried between polls. Once exhausted the final entry
+// is reused for every subsequent attempt.
+var backoffSchedule = []time.Duration{time.Second, 2 * time.Second, 5 * time.Second}
+
+// DependencyWaiter polls the watch endpoints of dispatched dependencies until each reports a
+// terminal DeploymentStatus or the deadline elapses. It replaces the old busy loop in
+// waitForDependencies which reallocated an http.Client per iteration, measured its timeout with
+// time.Now().UTC().Second() (wrapping every 60 seconds) and panicked on transport errors.
+type DependencyWaiter struct {
+	Client   *http.Client
+	Deadline time.Duration
+	// Broker, if set, receives every status pollOne observes for a dependency, keyed by its
+	// DeploymentName, so a /deploy/.../watch/{deployID} subscriber sees progress as it happens
+	// rather than only the terminal state.
+	Broker *StatusBroker
+	// InFlight, if set, is incremented for each dependency poll goroutine Wait starts and
+	// decremented when it finishes, so a GracefulServer shutting down can wait for them to drain
+	// instead of cutting them off mid-poll.
+	InFlight *sync.WaitGroup
+}
+
+// NewDependencyWaiter builds a DependencyWaiter sharing a single http.Client configured with
+// tlsConfig across every poll, rather than constructing one per iteration.
+func NewDependencyWaiter(tlsConfig *tls.Config, deadline time.Duration) *DependencyWaiter {
+	return &DependencyWaiter{
+		Client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		Deadline: deadline,
+	}
+}
+
+// dependencyResult pairs a dependency with the outcome of waiting on it.
+type dependencyResult struct {
+	dependency *Dispatched
+	status     DeploymentStatus
+	err        error
+}
+
+// describeOutcome reduces a dependency's observed status/err to a templateDeploysTotal outcome
+// label and, if it didn't succeed, a human-readable message describing why. It is shared by the
+// flat-list path (deployDependencyServices), the graph path (deployDependencyGraph) and
+// DependencyWaiter.Wait so the three can't drift into reporting the same kind of failure
+// differently.
+func describeOutcome(name string, status DeploymentStatus, err error) (outcome string, errMsg string) {
+	switch {
+	case err != nil && err == context.DeadlineExceeded:
+		return "failed", fmt.Sprintf("timed out waiting for dependency %s", name)
+	case err != nil:
+		return "failed", fmt.Sprintf("dependency %s: %s", name, err)
+	case status.State == DeploymentFailed:
+		return "failed", fmt.Sprintf("dependency %s reported failed: %s", name, status.Description)
+	default:
+		return "succeeded", ""
+	}
+}
+
+// describe is describeOutcome applied to a dependencyResult, keyed by the dependency's own
+// DeploymentName.
+func (result dependencyResult) describe() (outcome string, errMsg string) {
+	return describeOutcome(result.dependency.DeploymentName, result.status, result.err)
+}
+
+// Wait polls every dependency's WatchURL concurrently with exponential backoff and jitter until
+// it reports DeploymentSucceeded or DeploymentFailed, or ctx/the waiter's deadline expires. The
+// returned error, if any, distinguishes dependencies that timed out from dependencies that
+// reported failure so callers of Deployer.Template don't have to guess which happened.
+func (dw *DependencyWaiter) Wait(ctx context.Context, namespace string, payload *Payload, dependencies []*Dispatched) error {
+	results := dw.waitAll(ctx, namespace, payload, dependencies)
+
+	var errs []string
+	for _, result := range results {
+		if _, errMsg := result.describe(); errMsg != "" {
+			errs = append(errs, errMsg)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// waitAll polls every dependency's WatchURL concurrently with exponential backoff and jitter,
+// returning each one's outcome once it reaches a terminal DeploymentStatus or ctx/the waiter's
+// deadline expires. Wait reduces these to a single aggregate error; callers that need to record a
+// per-dependency outcome (e.g. deployDependencyServices' metrics) use waitAll directly instead.
+func (dw *DependencyWaiter) waitAll(ctx context.Context, namespace string, payload *Payload, dependencies []*Dispatched) []dependencyResult {
+	ctx, cancel := context.WithTimeout(ctx, dw.Deadline)
+	defer cancel()
+
+	inFlight := dependencyWaitsInFlight.WithLabelValues(namespace)
+	resultsCh := make(chan dependencyResult, len(dependencies))
+	var wg sync.WaitGroup
 	for _, dependency := range dependencies {
-		dependencyGroup.Add(1)
+		wg.Add(1)
+		inFlight.Inc()
+		if dw.InFlight != nil {
+			dw.InFlight.Add(1)
+		}
 		go func(dependency *Dispatched) {
-			defer dependencyGroup.Done()
-			// poll deploy for 5 minutes, waiting for success
-			timeout := 300
-			start := time.Now().UTC().Second()
-			for {
-				//accept self-signed certs
-				tr := &http.Transport{
-					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-				}
-				client := &http.Client{Transport: tr}
-
-				//set up authorization
-				var bearer = "Bearer " + payload.Target.Token
-				req, err := http.NewRequest("GET", dependency.WatchURL, nil)
-				if err != nil {
-					panic(err)
-				}
-				req.Header.Add("authorization", bearer)
-
-				//perform GET request
-				resp, err := client.Do(req)
-				if err != nil {
-					panic(err)
-				}
-
-				bodyBytes, _ := ioutil.ReadAll(resp.Body)
-				body := string(bodyBytes)
-
-				// if success exit
-				if strings.Contains(strings.ToLower(body), "success") {
-					return
-				}
-				//timed out, exit
-				if time.Now().UTC().Second()-start > timeout {
-					depErrors = append(depErrors, "Failed to deploy dependency: "+dependency.DeploymentName)
-				}
+			defer wg.Done()
+			defer inFlight.Dec()
+			if dw.InFlight != nil {
+				defer dw.InFlight.Done()
 			}
-
+			status, err := dw.pollOne(ctx, payload, dependency)
+			if err != nil && ctx.Err() == context.DeadlineExceeded {
+				dependencyWaitTimeoutsTotal.Inc()
+			}
+			resultsCh <- dependencyResult{dependency: dependency, status: status, err: err}
 		}(dependency)
 	}
+	go func() {
+		wg.Wait()
+		close(resultsCh)
+	}()
+
+	var results []dependencyResult
+	for result := range resultsCh {
+		results = append(results, result)
+	}
+	return results
+}
+
+// pollOne polls a single dependency's WatchURL, backing off between attempts, until it reaches a
+// terminal DeploymentStatus or ctx is done.
+func (dw *DependencyWaiter) pollOne(ctx context.Context, payload *Payload, dependency *Dispatched) (DeploymentStatus, error) {
+	for attempt := 0; ; attempt++ {
+		status, err := dw.fetchStatus(ctx, payload, dependency)
+		if err == nil {
+			dw.publish(dependency, status)
+			if status.State.terminal() {
+				return status, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return DeploymentStatus{}, ctx.Err()
+		case <-time.After(backoffDelay(attempt)):
+		}
+	}
+}
 
-	dependencyGroup.Wait()
+// publish forwards status for dependency to dw.Broker, keyed by the dependency's own deployment
+// name. It is a no-op when dw.Broker is nil, which is the case for any caller that hasn't wired up
+// status streaming.
+func (dw *DependencyWaiter) publish(dependency *Dispatched, status DeploymentStatus) {
+	if dw.Broker == nil {
+		return
+	}
+	dw.Broker.Publish(dependency.DeploymentName, status)
+}
 
-	// dependencies were not succesful, return an error
-	if len(depErrors) > 0 {
-		return errors.New(strings.Join(depErrors, "\n"))
+// fetchStatus makes a single GET request to the dependency's WatchURL and decodes the response
+// into a DeploymentStatus.
+func (dw *DependencyWaiter) fetchStatus(ctx context.Context, payload *Payload, dependency *Dispatched) (DeploymentStatus, error) {
+	req, err := http.NewRequest("GET", dependency.WatchURL, nil)
+	if err != nil {
+		return DeploymentStatus{}, errors.Wrap(err, "failed to build dependency watch request")
 	}
+	req = req.WithContext(ctx)
+	req.Header.Add("authorization", "Bearer "+payload.Target.Token)
 
-	return nil
-}
\ No newline at end of file
+	resp, err := dw.Client.Do(req)
+	if err != nil {
+		return DeploymentStatus{}, errors.Wrap(err, "failed to reach dependency watch endpoint")
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return DeploymentStatus{}, errors.Wrap(err, "failed to read dependency watch response")
+	}
+
+	var status DeploymentStatus
+	if err := json.Unmarshal(bodyBytes, &status); err != nil {
+		return DeploymentStatus{}, errors.Wrap(err, "failed to parse dependency watch response")
+	}
+	return status, nil
+}
+
+// backoffDelay returns the delay before the next poll attempt following the schedule defined by
+// backoffSchedule (capped at its last entry) and jittered by up to 50% so dependencies polled
+// concurrently don't all retry in lockstep.
+func backoffDelay(attempt int) time.Duration {
+	base := backoffSchedule[len(backoffSchedule)-1]
+	if attempt < len(backoffSchedule) {
+		base = backoffSchedule[attempt]
+	}
+	jitter := time.Duration(rand.Int63n(int64(base) / 2))
+	return base/2 + jitter
+}
+
+// waitForDependencies waits for every dispatched dependency to reach a terminal DeploymentStatus,
+// giving them up to 5 minutes in total. It is kept as the entry point existing callers use; new
+// code that needs a custom deadline or TLS configuration should construct a DependencyWaiter
+// directly. When broker is non-nil every observed status is published to it. When inFlight is
+// non-nil it is incremented/decremented around each dependency's poll goroutine, so a
+// GracefulServer can hold shutdown open until they drain.
+func waitForDependencies(ctx context.Context, client Client, namespace string, dependencies []*Dispatched, payload *Payload, broker *StatusBroker, inFlight *sync.WaitGroup) error {
+	waiter := NewDependencyWaiter(&tls.Config{InsecureSkipVerify: true}, 5*time.Minute)
+	waiter.Broker = broker
+	waiter.InFlight = inFlight
+	return waiter.Wait(ctx, namespace, payload, dependencies)
+}
@@ -0,0 +1,22 @@
+package deploy
+
+import "testing"
+
+func TestBackoffDelayWithinSchedule(t *testing.T) {
+	for attempt, scheduled := range backoffSchedule {
+		delay := backoffDelay(attempt)
+		min := scheduled / 2
+		max := scheduled
+		if delay < min || delay > max {
+			t.Errorf("attempt %d: expected delay in [%s, %s], got %s", attempt, min, max, delay)
+		}
+	}
+}
+
+func TestBackoffDelayCapsAtLastScheduleEntry(t *testing.T) {
+	last := backoffSchedule[len(backoffSchedule)-1]
+	delay := backoffDelay(len(backoffSchedule) + 10)
+	if delay < last/2 || delay > last {
+		t.Errorf("expected an attempt past the schedule's end to be capped at the last entry (%s), got %s", last, delay)
+	}
+}
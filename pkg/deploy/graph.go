@@ -0,0 +1,235 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DependencyNode describes a single entry in a DependencyGraph: what it depends on, any
+// parameter overrides to apply when it is deployed, and how to decide it is ready.
+type DependencyNode struct {
+	Name       string            `yaml:"name"`
+	Parameters map[string]string `yaml:"parameters"`
+	DependsOn  []string          `yaml:"depends_on"`
+	Readiness  *ReadinessProbe   `yaml:"readiness"`
+}
+
+// ReadinessProbe describes how a DependencyNode's readiness is decided: when Path is set, an HTTP
+// GET of Path must return ExpectedStatus. Without a Path the node is ready once its own watch
+// endpoint reports DeploymentSucceeded; that target state is not configurable.
+type ReadinessProbe struct {
+	Path           string `yaml:"path"`
+	ExpectedStatus int    `yaml:"expected_status"`
+}
+
+// DependencyGraph is the parsed form of a template's "dependencies.yaml" annotation: a named set
+// of nodes, deployed in topological order with independent nodes fanned out concurrently. Only
+// the annotation is read; no ConfigMap is consulted. Unlike the flat space-separated
+// "dependencies" annotation, which deployDependencyServices still falls back to when no
+// "dependencies.yaml" annotation is present, it can express ordering, parameter overrides and a
+// readiness gate.
+type DependencyGraph struct {
+	Nodes map[string]*DependencyNode
+}
+
+// ParseDependencyGraph parses doc as a YAML list of DependencyNode and validates that every
+// depends_on reference resolves to a node in the document and that the graph is acyclic.
+func ParseDependencyGraph(doc []byte) (*DependencyGraph, error) {
+	var nodes []*DependencyNode
+	if err := yaml.Unmarshal(doc, &nodes); err != nil {
+		return nil, errors.Wrap(err, "failed to parse dependency graph")
+	}
+
+	graph := &DependencyGraph{Nodes: map[string]*DependencyNode{}}
+	for _, n := range nodes {
+		if n.Name == "" {
+			return nil, errors.New("dependency graph node is missing a name")
+		}
+		graph.Nodes[n.Name] = n
+	}
+	for _, n := range nodes {
+		for _, dep := range n.DependsOn {
+			if _, ok := graph.Nodes[dep]; !ok {
+				return nil, fmt.Errorf("node %s depends_on unknown node %s", n.Name, dep)
+			}
+		}
+	}
+	if err := graph.detectCycle(); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// detectCycle walks the graph with the standard white/grey/black DFS colouring, returning an
+// error describing the first cycle found.
+func (g *DependencyGraph) detectCycle() error {
+	const (
+		white = iota
+		grey
+		black
+	)
+	color := map[string]int{}
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch color[name] {
+		case black:
+			return nil
+		case grey:
+			return fmt.Errorf("cyclic dependency detected: %s", strings.Join(append(path, name), " -> "))
+		}
+		color[name] = grey
+		for _, dep := range g.Nodes[name].DependsOn {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		color[name] = black
+		return nil
+	}
+
+	for name := range g.Nodes {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NodeResult is the outcome of deploying and waiting for readiness of a single DependencyGraph
+// node.
+type NodeResult struct {
+	Dispatched *Dispatched
+	Status     DeploymentStatus
+	Err        error
+}
+
+// GraphResult maps each node in a DependencyGraph to its final NodeResult, so partial failures
+// can be surfaced back through Controller.Template rather than the whole deploy failing blind.
+type GraphResult struct {
+	Nodes map[string]NodeResult
+}
+
+// GraphScheduler deploys a DependencyGraph's nodes in topological order: independent nodes are
+// deployed concurrently, and a node is held back until every node in its depends_on list has
+// reached Ready.
+type GraphScheduler struct {
+	Deployer Deployer
+	Waiter   *DependencyWaiter
+}
+
+// Run deploys every node in graph against client/namespace, applying payload with each node's
+// parameter overrides merged in, and returns a GraphResult describing the outcome of each node.
+func (s *GraphScheduler) Run(ctx context.Context, client Client, namespace string, graph *DependencyGraph, payload *Payload) *GraphResult {
+	result := &GraphResult{Nodes: map[string]NodeResult{}}
+	var mu sync.Mutex
+
+	done := map[string]chan struct{}{}
+	for name := range graph.Nodes {
+		done[name] = make(chan struct{})
+	}
+
+	var wg sync.WaitGroup
+	for name, node := range graph.Nodes {
+		wg.Add(1)
+		go func(name string, node *DependencyNode) {
+			defer wg.Done()
+			defer close(done[name])
+
+			for _, dep := range node.DependsOn {
+				<-done[dep]
+				mu.Lock()
+				parent := result.Nodes[dep]
+				mu.Unlock()
+				if parent.Err != nil || parent.Status.State != DeploymentSucceeded {
+					mu.Lock()
+					result.Nodes[name] = NodeResult{Err: fmt.Errorf("dependency %s did not become ready", dep)}
+					mu.Unlock()
+					return
+				}
+			}
+
+			dispatched, err := s.Deployer.Template(client, name, namespace, payloadWithParameters(payload, node.Parameters))
+			if err != nil {
+				mu.Lock()
+				result.Nodes[name] = NodeResult{Err: err}
+				mu.Unlock()
+				return
+			}
+
+			if s.Waiter.InFlight != nil {
+				s.Waiter.InFlight.Add(1)
+				defer s.Waiter.InFlight.Done()
+			}
+			status, err := s.nodeReady(ctx, payload, dispatched, node)
+			mu.Lock()
+			result.Nodes[name] = NodeResult{Dispatched: dispatched, Status: status, Err: err}
+			mu.Unlock()
+		}(name, node)
+	}
+	wg.Wait()
+
+	return result
+}
+
+// nodeReady blocks until node's readiness condition is satisfied: an HTTP probe against
+// Readiness.Path when set, otherwise the typed DeploymentStatus from its watch endpoint.
+func (s *GraphScheduler) nodeReady(ctx context.Context, payload *Payload, dispatched *Dispatched, node *DependencyNode) (DeploymentStatus, error) {
+	if node.Readiness != nil && node.Readiness.Path != "" {
+		return s.probeHTTPReadiness(ctx, dispatched, node.Readiness)
+	}
+	return s.Waiter.pollOne(ctx, payload, dispatched)
+}
+
+// probeHTTPReadiness polls Readiness.Path until it returns Readiness.ExpectedStatus or ctx
+// expires.
+func (s *GraphScheduler) probeHTTPReadiness(ctx context.Context, dispatched *Dispatched, readiness *ReadinessProbe) (DeploymentStatus, error) {
+	for attempt := 0; ; attempt++ {
+		if req, err := http.NewRequest("GET", dispatched.WatchURL+readiness.Path, nil); err == nil {
+			req = req.WithContext(ctx)
+			if resp, err := s.Waiter.Client.Do(req); err == nil {
+				resp.Body.Close()
+				status := DeploymentStatus{State: DeploymentRunning}
+				if resp.StatusCode == readiness.ExpectedStatus {
+					status.State = DeploymentSucceeded
+				}
+				s.Waiter.publish(dispatched, status)
+				if status.State == DeploymentSucceeded {
+					return status, nil
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return DeploymentStatus{}, ctx.Err()
+		case <-time.After(backoffDelay(attempt)):
+		}
+	}
+}
+
+// payloadWithParameters returns a copy of payload with overrides merged into its Parameters, for
+// passing a node's per-dependency parameter overrides through to Deployer.Template without
+// mutating the caller's Payload.
+func payloadWithParameters(payload *Payload, overrides map[string]string) *Payload {
+	if len(overrides) == 0 {
+		return payload
+	}
+	merged := *payload
+	params := make(map[string]string, len(merged.Parameters)+len(overrides))
+	for k, v := range merged.Parameters {
+		params[k] = v
+	}
+	for k, v := range overrides {
+		params[k] = v
+	}
+	merged.Parameters = params
+	return &merged
+}
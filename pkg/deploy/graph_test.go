@@ -0,0 +1,69 @@
+package deploy
+
+import "testing"
+
+func TestParseDependencyGraph(t *testing.T) {
+	doc := []byte(`
+- name: data-mongo
+- name: cloud-app
+  depends_on: [data-mongo]
+`)
+	graph, err := ParseDependencyGraph(doc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(graph.Nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(graph.Nodes))
+	}
+	if got := graph.Nodes["cloud-app"].DependsOn; len(got) != 1 || got[0] != "data-mongo" {
+		t.Fatalf("expected cloud-app to depend on data-mongo, got %v", got)
+	}
+}
+
+func TestParseDependencyGraphMissingName(t *testing.T) {
+	doc := []byte(`
+- depends_on: []
+`)
+	if _, err := ParseDependencyGraph(doc); err == nil {
+		t.Fatal("expected an error for a node with no name")
+	}
+}
+
+func TestParseDependencyGraphUnknownDependency(t *testing.T) {
+	doc := []byte(`
+- name: cloud-app
+  depends_on: [data-mongo]
+`)
+	_, err := ParseDependencyGraph(doc)
+	if err == nil {
+		t.Fatal("expected an error for a depends_on referencing an unknown node")
+	}
+}
+
+func TestParseDependencyGraphDetectsCycle(t *testing.T) {
+	doc := []byte(`
+- name: a
+  depends_on: [b]
+- name: b
+  depends_on: [a]
+`)
+	_, err := ParseDependencyGraph(doc)
+	if err == nil {
+		t.Fatal("expected an error for a cyclic graph")
+	}
+}
+
+func TestParseDependencyGraphAcyclicDiamond(t *testing.T) {
+	doc := []byte(`
+- name: a
+- name: b
+  depends_on: [a]
+- name: c
+  depends_on: [a]
+- name: d
+  depends_on: [b, c]
+`)
+	if _, err := ParseDependencyGraph(doc); err != nil {
+		t.Fatalf("unexpected error for an acyclic diamond-shaped graph: %s", err)
+	}
+}
@@ -0,0 +1,157 @@
+package deploy
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/kubernetes/pkg/apis/batch"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// attemptAnnotation records, on a configuration Job, how many times it has been resubmitted after
+// a previous attempt failed, so FindJobByName callers can tell a retried Job apart from a brand
+// new one and a restarted negotiator can pick the count back up.
+const attemptAnnotation = "rhmap/attempt"
+
+// RetryPolicy controls how many times DataMongoConfigure/DataMysqlConfigure resubmit a
+// configuration Job after it fails, and how long they back off between attempts.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy is used in place of a Configurer's zero-value RetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: 5 * time.Second, MaxBackoff: time.Minute}
+
+// orDefault substitutes DefaultRetryPolicy for a zero-value policy, the same "defaulted if unset"
+// convention NewEnvironmentServiceConfigController already uses for a nil StatusPublisher.
+func (p RetryPolicy) orDefault() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		return DefaultRetryPolicy
+	}
+	return p
+}
+
+// backoff returns the delay before retry attempt (0-based), doubling InitialBackoff on each
+// attempt and capping at MaxBackoff.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 0; i < attempt; i++ {
+		delay *= 2
+		if delay >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// jobSucceeded reports whether job has reached a Complete condition. This is the only state that
+// should stop a future Configure call from re-running configuration against a Job FindJobByName
+// turns up - a Failed/DeadlineExceeded Job must not short-circuit retry.
+func jobSucceeded(job *batch.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == "Complete" {
+			return true
+		}
+	}
+	return false
+}
+
+// jobAttempt reads job's attemptAnnotation, defaulting to 0 for a Job that predates this
+// annotation or was never retried.
+func jobAttempt(job *batch.Job) int {
+	n, err := strconv.Atoi(job.Annotations[attemptAnnotation])
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// jobOutcome is the terminal result watchConfigJob observed for a single attempt.
+type jobOutcome int
+
+const (
+	jobOutcomeSucceeded jobOutcome = iota
+	jobOutcomeFailed
+)
+
+// watchConfigJob watches a just-created configuration Job until it reports Complete, a
+// Failed/DeadlineExceeded condition, or the watch itself errors. On failure it captures the
+// failed pod's logs and deletes the Job so a subsequent attempt isn't blocked by the tombstone.
+func watchConfigJob(client Client, namespace, jobName string, w watch.Interface, statusUpdate func(description, status string)) jobOutcome {
+	result := w.ResultChan()
+	for ws := range result {
+		switch ws.Type {
+		case watch.Added, watch.Modified:
+			j := ws.Object.(*batch.Job)
+			statusUpdate(fmt.Sprintf("job status succeeded %d failed %d", j.Status.Succeeded, j.Status.Failed), configInProgress)
+			if j.Status.Succeeded >= 1 && jobSucceeded(j) {
+				w.Stop()
+				statusUpdate("configuration job succeeded ", configInProgress)
+				return jobOutcomeSucceeded
+			}
+			for _, condition := range j.Status.Conditions {
+				if condition.Reason == "DeadlineExceeded" || condition.Type == "Failed" {
+					w.Stop()
+					return failJobAndCleanUp(client, namespace, jobName, "configuration job failed: "+condition.Message, statusUpdate)
+				}
+			}
+		case watch.Error:
+			w.Stop()
+			return failJobAndCleanUp(client, namespace, jobName, "configuration job watch error", statusUpdate)
+		}
+	}
+	return jobOutcomeFailed
+}
+
+// failJobAndCleanUp fetches jobName's failed pod logs, appends them to description, publishes it,
+// and deletes the Job so the next attempt (if any) doesn't collide with the tombstone.
+func failJobAndCleanUp(client Client, namespace, jobName, description string, statusUpdate func(description, status string)) jobOutcome {
+	if logs, err := client.GetPodLogsByJob(namespace, jobName); err == nil {
+		description += "\npod log:\n" + logs
+	} else {
+		description += "\nfailed to retrieve pod logs: " + err.Error()
+	}
+	statusUpdate(description, configError)
+	if err := client.DeleteJob(namespace, jobName); err != nil {
+		statusUpdate("failed to delete failed configuration job "+jobName+": "+err.Error(), configError)
+	}
+	return jobOutcomeFailed
+}
+
+// runConfigJobWithRetry submits a configuration Job via submit, watches it to completion, and on
+// failure resubmits (with a fresh attempt number so the Job's attemptAnnotation keeps climbing)
+// up to policy.MaxAttempts, backing off between attempts. startAttempt lets a Job that already
+// failed in a previous Configure call carry its attempt count forward instead of resetting it.
+// onSuccess, if non-nil, runs once the Job completes successfully, before runConfigJobWithRetry
+// returns - DataMysqlConfigure uses it to block a replicated topology's Configure on replication
+// catching up before reporting configComplete.
+func runConfigJobWithRetry(client Client, namespace string, policy RetryPolicy, startAttempt int, statusUpdate func(description, status string), submit func(attempt int) (*batch.Job, error), onSuccess func()) {
+	policy = policy.orDefault()
+	for attempt := startAttempt; attempt < policy.MaxAttempts; attempt++ {
+		job, err := submit(attempt)
+		if err != nil {
+			statusUpdate("failed to build configuration job: "+err.Error(), configError)
+			return
+		}
+		w, err := client.CreateJobToWatch(job, namespace)
+		if err != nil {
+			statusUpdate("failed to CreateJobToWatch "+err.Error(), configError)
+			return
+		}
+		if watchConfigJob(client, namespace, job.GetName(), w, statusUpdate) == jobOutcomeSucceeded {
+			if onSuccess != nil {
+				onSuccess()
+			}
+			return
+		}
+		if attempt+1 >= policy.MaxAttempts {
+			statusUpdate(fmt.Sprintf("configuration job %s did not succeed after %d attempts, giving up", job.GetName(), attempt+1), configError)
+			return
+		}
+		statusUpdate(fmt.Sprintf("retrying configuration job %s (attempt %d of %d)", job.GetName(), attempt+2, policy.MaxAttempts), configInProgress)
+		time.Sleep(policy.backoff(attempt - startAttempt))
+	}
+}
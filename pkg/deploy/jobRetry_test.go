@@ -0,0 +1,196 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+
+	k8api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/batch"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+func TestJobAttempt(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		want        int
+	}{
+		{name: "no annotation", annotations: nil, want: 0},
+		{name: "valid annotation", annotations: map[string]string{attemptAnnotation: "2"}, want: 2},
+		{name: "invalid annotation", annotations: map[string]string{attemptAnnotation: "not-a-number"}, want: 0},
+	}
+	for _, c := range cases {
+		job := &batch.Job{ObjectMeta: k8api.ObjectMeta{Annotations: c.annotations}}
+		if got := jobAttempt(job); got != c.want {
+			t.Errorf("%s: expected %d, got %d", c.name, c.want, got)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second}, // would be 16s uncapped, clamped to MaxBackoff
+		{10, 10 * time.Second},
+	}
+	for _, c := range cases {
+		if got := policy.backoff(c.attempt); got != c.want {
+			t.Errorf("attempt %d: expected %s, got %s", c.attempt, c.want, got)
+		}
+	}
+}
+
+func TestRetryPolicyOrDefault(t *testing.T) {
+	if got := (RetryPolicy{}).orDefault(); got != DefaultRetryPolicy {
+		t.Errorf("expected a zero-value RetryPolicy to default to DefaultRetryPolicy, got %+v", got)
+	}
+	custom := RetryPolicy{MaxAttempts: 7, InitialBackoff: time.Second, MaxBackoff: time.Minute}
+	if got := custom.orDefault(); got != custom {
+		t.Errorf("expected a non-zero RetryPolicy to be returned unchanged, got %+v", got)
+	}
+}
+
+// fakeWatch is a watch.Interface whose events are fed in by the test.
+type fakeWatch struct {
+	events  chan watch.Event
+	stopped bool
+}
+
+func newFakeWatch() *fakeWatch {
+	return &fakeWatch{events: make(chan watch.Event, 8)}
+}
+
+func (f *fakeWatch) Stop()                  { f.stopped = true }
+func (f *fakeWatch) ResultChan() <-chan watch.Event { return f.events }
+
+// fakeRetryClient is a minimal Client fake for exercising runConfigJobWithRetry: every
+// CreateJobToWatch call pops the next queued watch, and DeleteJob/GetPodLogsByJob are recorded so
+// a test can assert failJobAndCleanUp cleaned up after a failed attempt.
+type fakeRetryClient struct {
+	Client
+	watches     []*fakeWatch
+	created     []*batch.Job
+	deletedJobs []string
+}
+
+func (f *fakeRetryClient) CreateJobToWatch(job *batch.Job, namespace string) (watch.Interface, error) {
+	f.created = append(f.created, job)
+	w := f.watches[len(f.created)-1]
+	return w, nil
+}
+
+func (f *fakeRetryClient) DeleteJob(namespace, jobName string) error {
+	f.deletedJobs = append(f.deletedJobs, jobName)
+	return nil
+}
+
+func (f *fakeRetryClient) GetPodLogsByJob(namespace, jobName string) (string, error) {
+	return "", nil
+}
+
+func succeededJob(name string) *batch.Job {
+	return &batch.Job{
+		ObjectMeta: k8api.ObjectMeta{Name: name},
+		Status: batch.JobStatus{
+			Succeeded:  1,
+			Conditions: []batch.JobCondition{{Type: "Complete"}},
+		},
+	}
+}
+
+func failedJob(name string) *batch.Job {
+	return &batch.Job{
+		ObjectMeta: k8api.ObjectMeta{Name: name},
+		Status: batch.JobStatus{
+			Failed:     1,
+			Conditions: []batch.JobCondition{{Type: "Failed", Message: "boom"}},
+		},
+	}
+}
+
+func TestRunConfigJobWithRetrySucceedsFirstAttempt(t *testing.T) {
+	succeed := newFakeWatch()
+	succeed.events <- watch.Event{Type: watch.Added, Object: succeededJob("job-1")}
+	client := &fakeRetryClient{watches: []*fakeWatch{succeed}}
+
+	var statuses []string
+	statusUpdate := func(description, status string) { statuses = append(statuses, status) }
+
+	onSuccessCalled := false
+	submit := func(attempt int) (*batch.Job, error) {
+		return &batch.Job{ObjectMeta: k8api.ObjectMeta{Name: "job-1"}}, nil
+	}
+
+	runConfigJobWithRetry(client, "ns", DefaultRetryPolicy, 0, statusUpdate, submit, func() { onSuccessCalled = true })
+
+	if len(client.created) != 1 {
+		t.Fatalf("expected exactly one job to be submitted, got %d", len(client.created))
+	}
+	if !onSuccessCalled {
+		t.Error("expected onSuccess to be called after a successful attempt")
+	}
+	if len(client.deletedJobs) != 0 {
+		t.Errorf("expected no cleanup deletes on a successful attempt, got %v", client.deletedJobs)
+	}
+}
+
+func TestRunConfigJobWithRetryRetriesThenSucceeds(t *testing.T) {
+	failing := newFakeWatch()
+	failing.events <- watch.Event{Type: watch.Added, Object: failedJob("job-1")}
+	succeeding := newFakeWatch()
+	succeeding.events <- watch.Event{Type: watch.Added, Object: succeededJob("job-2")}
+	client := &fakeRetryClient{watches: []*fakeWatch{failing, succeeding}}
+
+	attempts := []int{}
+	submit := func(attempt int) (*batch.Job, error) {
+		attempts = append(attempts, attempt)
+		name := "job-1"
+		if attempt > 0 {
+			name = "job-2"
+		}
+		return &batch.Job{ObjectMeta: k8api.ObjectMeta{Name: name}}, nil
+	}
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	runConfigJobWithRetry(client, "ns", policy, 0, func(string, string) {}, submit, nil)
+
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempts (1 failure + 1 success), got %d", len(attempts))
+	}
+	if len(client.deletedJobs) != 1 || client.deletedJobs[0] != "job-1" {
+		t.Errorf("expected the failed job-1 to be cleaned up, got %v", client.deletedJobs)
+	}
+}
+
+func TestRunConfigJobWithRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	watches := []*fakeWatch{newFakeWatch(), newFakeWatch()}
+	for _, w := range watches {
+		w.events <- watch.Event{Type: watch.Added, Object: failedJob("job")}
+	}
+	client := &fakeRetryClient{watches: watches}
+
+	var finalStatus string
+	statusUpdate := func(description, status string) { finalStatus = status }
+	submit := func(attempt int) (*batch.Job, error) {
+		return &batch.Job{ObjectMeta: k8api.ObjectMeta{Name: "job"}}, nil
+	}
+
+	policy := RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	runConfigJobWithRetry(client, "ns", policy, 0, statusUpdate, submit, func() {
+		t.Error("onSuccess must not be called when every attempt fails")
+	})
+
+	if len(client.created) != 2 {
+		t.Fatalf("expected exactly policy.MaxAttempts (2) submissions, got %d", len(client.created))
+	}
+	if finalStatus != configError {
+		t.Errorf("expected the final status to be configError, got %q", finalStatus)
+	}
+}
@@ -0,0 +1,49 @@
+package deploy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics collects Prometheus instrumentation for the deploy pipeline. It is registered once at
+// startup and shared by Controller.Template, deployDependencyServices and DependencyWaiter so
+// operators can see what the pipeline is doing rather than only what the HTTP layer is doing.
+var (
+	templateDeploysTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "negotiator",
+		Subsystem: "deploy",
+		Name:      "templates_total",
+		Help:      "Count of template deploys by template, namespace and outcome.",
+	}, []string{"template", "namespace", "outcome"})
+
+	deployDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "negotiator",
+		Subsystem: "deploy",
+		Name:      "duration_seconds",
+		Help:      "End to end latency of a template deploy, including dependency fan-out.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"template", "namespace"})
+
+	dependencyWaitsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "negotiator",
+		Subsystem: "deploy",
+		Name:      "dependency_waits_in_flight",
+		Help:      "Number of dependencies currently being polled for readiness.",
+	}, []string{"namespace"})
+
+	dependencyWaitTimeoutsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "negotiator",
+		Subsystem: "deploy",
+		Name:      "dependency_wait_timeouts_total",
+		Help:      "Count of dependencies that did not reach a terminal state before the wait deadline.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(templateDeploysTotal, deployDuration, dependencyWaitsInFlight, dependencyWaitTimeoutsTotal)
+}
+
+// observeDeployOutcome records the outcome of a single template deploy and its latency.
+func observeDeployOutcome(template, namespace, outcome string, seconds float64) {
+	templateDeploysTotal.WithLabelValues(template, namespace, outcome).Inc()
+	deployDuration.WithLabelValues(template, namespace).Observe(seconds)
+}
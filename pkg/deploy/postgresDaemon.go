@@ -0,0 +1,238 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	dc "github.com/openshift/origin/pkg/deploy/api"
+	k8api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/batch"
+	"k8s.io/kubernetes/pkg/runtime"
+)
+
+const templateDataPostgres = "data-postgres"
+
+// PostgresConfigurerDaemon is the reference implementation of the out-of-process Configurer
+// protocol: it registers itself with negotiator's RemoteConfigurerRegistry over HTTP, long-polls
+// AcquireJob for work, and reports progress/completion back the same way. It runs as its own
+// process via Run rather than being invoked in-process through a Go interface, so a third party
+// adding a new backing service never has to statically link into, or recompile, negotiator
+// itself.
+type PostgresConfigurerDaemon struct {
+	// BaseURL is negotiator's address, e.g. "http://negotiator:3000".
+	BaseURL string
+	// Secret authenticates this daemon's calls; it must match what was passed to Register.
+	Secret     string
+	HTTPClient *http.Client
+
+	// Client and TemplateLoader are the negotiator-internal dependencies this reference daemon
+	// happens to be compiled against for convenience of this example. An out-of-tree daemon
+	// would instead hold its own Kubernetes client and template store.
+	Client         Client
+	TemplateLoader TemplateLoader
+}
+
+// Run registers p with negotiator and then loops AcquireJob until ctx is cancelled, configuring
+// each ConfigurationJob it acquires and reporting progress and outcome back over HTTP. A
+// transient acquireJob error (negotiator restarting, a dropped connection) backs off and retries
+// rather than ending the loop - the same "don't give up on one blip" guarantee the in-process
+// retry/backoff machinery gives configuration Jobs themselves.
+func (p *PostgresConfigurerDaemon) Run(ctx context.Context) error {
+	if err := p.register(); err != nil {
+		return errors.Wrap(err, "failed to register with negotiator")
+	}
+	for acquireFailures := 0; ; {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		job, err := p.acquireJob()
+		if err != nil {
+			acquireFailures++
+			time.Sleep(backoffDelay(acquireFailures - 1))
+			continue
+		}
+		acquireFailures = 0
+		if job == nil {
+			continue
+		}
+
+		deployment, err := p.configure(job)
+		if err != nil {
+			p.completeJob(job.ID, nil, err.Error())
+			continue
+		}
+		p.completeJob(job.ID, deployment, "")
+	}
+}
+
+// register tells negotiator this daemon handles templateDataPostgres.
+func (p *PostgresConfigurerDaemon) register() error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"serviceName": templateDataPostgres,
+		"secret":      p.Secret,
+	})
+	resp, err := p.HTTPClient.Post(p.BaseURL+"/configurers/register", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status registering: %s", resp.Status)
+	}
+	return nil
+}
+
+// acquireJob long-polls negotiator for the next queued ConfigurationJob for
+// templateDataPostgres. A nil job with a nil error means the long-poll window elapsed with no
+// work queued; Run simply calls again. The secret travels in the JSON body, like every other
+// call this daemon makes, rather than the URL, so it doesn't end up in access or proxy logs.
+func (p *PostgresConfigurerDaemon) acquireJob() (*ConfigurationJob, error) {
+	body, _ := json.Marshal(map[string]string{"secret": p.Secret})
+	url := fmt.Sprintf("%s/configurers/%s/jobs/acquire", p.BaseURL, templateDataPostgres)
+	resp, err := p.HTTPClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status acquiring job: %s", resp.Status)
+	}
+	var job ConfigurationJob
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// statusUpdate reports description/status for jobID back to negotiator, best-effort - a failed
+// report doesn't abort the configure attempt it describes.
+func (p *PostgresConfigurerDaemon) statusUpdate(jobID, status, description string) {
+	body, _ := json.Marshal(map[string]string{
+		"secret":      p.Secret,
+		"status":      status,
+		"description": description,
+	})
+	url := fmt.Sprintf("%s/configurers/%s/jobs/%s/status", p.BaseURL, templateDataPostgres, jobID)
+	if resp, err := p.HTTPClient.Post(url, "application/json", bytes.NewReader(body)); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// completeJob reports jobID's final outcome to negotiator so the Configure call waiting on it
+// returns.
+func (p *PostgresConfigurerDaemon) completeJob(jobID string, deployment *dc.DeploymentConfig, errMsg string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"secret":     p.Secret,
+		"deployment": deployment,
+		"error":      errMsg,
+	})
+	url := fmt.Sprintf("%s/configurers/%s/jobs/%s/complete", p.BaseURL, templateDataPostgres, jobID)
+	if resp, err := p.HTTPClient.Post(url, "application/json", bytes.NewReader(body)); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// configure provisions a database/user for job.Deployment against the data-postgres service and
+// sets FH_POSTGRES_CONN_URL on its containers, then runs the data-postgres-job Job and waits for
+// it to succeed.
+func (p *PostgresConfigurerDaemon) configure(job *ConfigurationJob) (*dc.DeploymentConfig, error) {
+	namespace := job.Namespace
+	deployment := job.Deployment
+	p.statusUpdate(job.ID, configInProgress, "provisioning postgres database for "+deployment.Name)
+
+	dataDc, err := p.Client.FindDeploymentConfigsByLabel(namespace, map[string]string{"rhmap/name": templateDataPostgres})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find data-postgres DeploymentConfig")
+	}
+	if len(dataDc) == 0 {
+		return nil, errors.New("no data-postgres DeploymentConfig exists. Cannot continue")
+	}
+	dataService, err := p.Client.FindServiceByLabel(namespace, map[string]string{"rhmap/name": templateDataPostgres})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find data-postgres service")
+	}
+	if len(dataService) == 0 {
+		return nil, errors.New("no service for data-postgres found. Cannot continue")
+	}
+
+	containerEnv := dataDc[0].Spec.Template.Spec.Containers[0].Env
+	adminPassword := ""
+	for _, e := range containerEnv {
+		if e.Name == "POSTGRESQL_ADMIN_PASSWORD" {
+			adminPassword = e.Value
+			break
+		}
+	}
+	if adminPassword == "" {
+		return nil, errors.New("expected to find an env var: POSTGRESQL_ADMIN_PASSWORD but it was not present")
+	}
+
+	jobOpts := map[string]interface{}{
+		"name":          deployment.Name,
+		"dbhost":        dataService[0].GetName(),
+		"admin-user":    "postgres",
+		"admin-pass":    adminPassword,
+		"database":      deployment.Name,
+		"database-user": deployment.Name,
+		"database-pass": genPass(16),
+	}
+	if v, ok := deployment.Labels["rhmap/guid"]; ok {
+		jobOpts["database"] = v
+		jobOpts["database-user"] = v
+	}
+
+	connURL := fmt.Sprintf("postgres://%s:%s@%s:5432/%s", jobOpts["database-user"], jobOpts["database-pass"], jobOpts["dbhost"], jobOpts["database"])
+	for ci := range deployment.Spec.Template.Spec.Containers {
+		env := deployment.Spec.Template.Spec.Containers[ci].Env
+		found := false
+		for ei, e := range env {
+			if e.Name == "FH_POSTGRES_CONN_URL" {
+				deployment.Spec.Template.Spec.Containers[ci].Env[ei].Value = connURL
+				found = true
+				break
+			}
+		}
+		if !found {
+			deployment.Spec.Template.Spec.Containers[ci].Env = append(deployment.Spec.Template.Spec.Containers[ci].Env, k8api.EnvVar{
+				Name:  "FH_POSTGRES_CONN_URL",
+				Value: connURL,
+			})
+		}
+	}
+
+	tpl, err := p.TemplateLoader.Load("data-postgres-job")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load template data-postgres-job")
+	}
+	var buf bytes.Buffer
+	if err := tpl.ExecuteTemplate(&buf, "data-postgres-job", jobOpts); err != nil {
+		return nil, errors.Wrap(err, "failed to execute template")
+	}
+	j := &batch.Job{}
+	if err := runtime.DecodeInto(k8api.Codecs.UniversalDecoder(), buf.Bytes(), j); err != nil {
+		return nil, errors.Wrap(err, "failed to decode job")
+	}
+	w, err := p.Client.CreateJobToWatch(j, namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to CreateJobToWatch")
+	}
+	relayedUpdate := func(description, status string) { p.statusUpdate(job.ID, status, description) }
+	if watchConfigJob(p.Client, namespace, j.GetName(), w, relayedUpdate) != jobOutcomeSucceeded {
+		return nil, errors.New("data-postgres-job did not succeed, see prior status updates for details")
+	}
+
+	p.statusUpdate(job.ID, configComplete, "provisioned postgres database for "+deployment.Name)
+	return deployment, nil
+}
@@ -0,0 +1,85 @@
+package reconciler
+
+import (
+	"time"
+
+	"github.com/feedhenry/negotiator/pkg/deploy"
+	"github.com/feedhenry/negotiator/pkg/log"
+)
+
+// Controller is the subset of EnvironmentServiceConfigController the Scheduler depends on, so
+// tests can supply a fake rather than a real OpenShift client.
+type Controller interface {
+	Reconcile(client deploy.Client, config *deploy.Configuration) error
+}
+
+// Scheduler periodically re-runs Controller.Reconcile for every app DeploymentConfig (one not
+// labeled rhmap/type=environmentService) in its watched namespaces, correcting configuration
+// drift that has crept in since the last provision or reconcile pass.
+type Scheduler struct {
+	Client     deploy.Client
+	Controller Controller
+	Namespaces []string
+	Logger     log.Logger
+	Interval   time.Duration
+
+	stop chan struct{}
+}
+
+// NewScheduler builds a Scheduler that reconciles namespaces every interval.
+func NewScheduler(client deploy.Client, controller Controller, namespaces []string, logger log.Logger, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		Client:     client,
+		Controller: controller,
+		Namespaces: namespaces,
+		Logger:     logger,
+		Interval:   interval,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Start runs the reconcile loop until Stop is called. It is intended to be run in its own
+// goroutine.
+func (s *Scheduler) Start() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.reconcileAll()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Stop ends the reconcile loop started by Start.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+}
+
+// reconcileAll enumerates app DeploymentConfigs (those not labeled rhmap/type=environmentService)
+// in every watched namespace and reconciles each one in turn.
+func (s *Scheduler) reconcileAll() {
+	for _, namespace := range s.Namespaces {
+		deployments, err := s.Client.FindDeploymentConfigsByLabel(namespace, map[string]string{})
+		if err != nil {
+			s.Logger.Error("reconciler: failed to list DeploymentConfigs in " + namespace + " " + err.Error())
+			continue
+		}
+		for _, d := range deployments {
+			if d.Labels["rhmap/type"] == "environmentService" {
+				continue
+			}
+			config := &deploy.Configuration{
+				DeploymentName: d.Name,
+				NameSpace:      namespace,
+				InstanceID:     d.Name,
+				Action:         "reconcile",
+			}
+			if err := s.Controller.Reconcile(s.Client, config); err != nil {
+				s.Logger.Error("reconciler: failed to reconcile " + d.Name + " in " + namespace + " " + err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package deploy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	dc "github.com/openshift/origin/pkg/deploy/api"
+)
+
+// acquireJobLongPollTimeout bounds how long a single AcquireJob call blocks waiting for a job to
+// enqueue before returning (nil, nil) so a polling daemon gets a chance to re-check its own
+// context/deadline rather than being held open forever.
+const acquireJobLongPollTimeout = 30 * time.Second
+
+// configureJobTimeout bounds how long remoteConfigurer.Configure waits for a daemon to acquire and
+// complete a job before giving up, the same "don't hang forever" guarantee DependencyWaiter gives
+// the dependency-graph path.
+const configureJobTimeout = 10 * time.Minute
+
+// ConfigurationJob is the unit of work a daemon acquires via RemoteConfigurerRegistry.AcquireJob:
+// configure deployment for serviceName/namespace. It crosses the wire as-is, so daemons never link
+// against this package - only its JSON shape.
+type ConfigurationJob struct {
+	ID          string                `json:"id"`
+	ServiceName string                `json:"serviceName"`
+	Namespace   string                `json:"namespace"`
+	Deployment  *dc.DeploymentConfig  `json:"deployment"`
+}
+
+// remoteJob is the server-side bookkeeping for a ConfigurationJob: the channel it's handed out on
+// once acquired, and the channel its outcome is delivered on once a daemon calls CompleteJob.
+type remoteJob struct {
+	job    ConfigurationJob
+	status chan Status
+	done   chan jobResult
+}
+
+// jobResult is what a daemon reports via CompleteJob: the mutated DeploymentConfig on success, or
+// a description of what went wrong.
+type jobResult struct {
+	deployment *dc.DeploymentConfig
+	err        error
+}
+
+// registeredDaemon records a daemon's shared secret and the tags it advertised at Register time.
+// Tags are surfaced for operators (e.g. to tell a canary build's daemon apart from the primary one
+// in logs/metrics) but AcquireJob does not yet route jobs by tag - there is exactly one queue per
+// serviceName, served FIFO to whichever registered daemon calls AcquireJob first.
+type registeredDaemon struct {
+	secret string
+	tags   []string
+}
+
+// RemoteConfigurerRegistry is the out-of-process Configurer protocol's server side: daemons
+// Register the service names (and optional tags) they handle, long-poll AcquireJob for work
+// negotiator has queued via remoteConfigurer.Configure, and report progress/completion back via
+// PublishStatus/CompleteJob. Every call but Register is authenticated against the secret supplied
+// at registration time, so a daemon can only pull and complete jobs for services it proved it
+// owns.
+type RemoteConfigurerRegistry struct {
+	mu       sync.Mutex
+	daemons  map[string]registeredDaemon
+	pending  map[string]chan *remoteJob
+	inFlight map[string]*remoteJob
+}
+
+// NewRemoteConfigurerRegistry returns an empty RemoteConfigurerRegistry.
+func NewRemoteConfigurerRegistry() *RemoteConfigurerRegistry {
+	return &RemoteConfigurerRegistry{
+		daemons:  map[string]registeredDaemon{},
+		pending:  map[string]chan *remoteJob{},
+		inFlight: map[string]*remoteJob{},
+	}
+}
+
+// Register associates serviceName with secret and tags. Registering again for an already
+// registered serviceName replaces the previous registration, so a restarted daemon can reconnect
+// without an operator having to intervene; any jobs already queued for it are unaffected.
+func (r *RemoteConfigurerRegistry) Register(serviceName, secret string, tags []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.daemons[serviceName] = registeredDaemon{secret: secret, tags: tags}
+	if r.pending[serviceName] == nil {
+		r.pending[serviceName] = make(chan *remoteJob, 16)
+	}
+}
+
+// Registered reports whether any daemon has registered for serviceName; used by
+// ConfigurationFactory.Factory to decide whether to fall back to a remoteConfigurer.
+func (r *RemoteConfigurerRegistry) Registered(serviceName string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.daemons[serviceName]
+	return ok
+}
+
+// VerifySecret reports whether secret matches the one serviceName's daemon registered with. The
+// HTTP layer calls this to authenticate AcquireJob/PublishStatus/CompleteJob before serving them.
+func (r *RemoteConfigurerRegistry) VerifySecret(serviceName, secret string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reg, ok := r.daemons[serviceName]
+	return ok && reg.secret == secret
+}
+
+// enqueue queues a ConfigurationJob for serviceName and returns the remoteJob handle
+// remoteConfigurer.Configure uses to relay status and wait for its outcome. It fails fast rather
+// than blocking if serviceName's queue is already full (acquireJobLongPollTimeout's daemon isn't
+// draining it), so a stalled daemon can't hang Configure before its own configureJobTimeout even
+// starts counting.
+func (r *RemoteConfigurerRegistry) enqueue(serviceName, namespace string, deployment *dc.DeploymentConfig) (*remoteJob, error) {
+	r.mu.Lock()
+	ch, ok := r.pending[serviceName]
+	r.mu.Unlock()
+	if !ok {
+		return nil, errors.New("no configurer daemon registered for " + serviceName)
+	}
+
+	rj := &remoteJob{
+		job: ConfigurationJob{
+			ID:          serviceName + "-" + namespace + "-" + deployment.Name + "-" + genPass(8),
+			ServiceName: serviceName,
+			Namespace:   namespace,
+			Deployment:  deployment,
+		},
+		status: make(chan Status, 16),
+		done:   make(chan jobResult, 1),
+	}
+
+	// Register in inFlight before handing rj to a daemon - a daemon blocked in AcquireJob can
+	// acquire and report on a job as soon as it's sent on ch, and authenticatedJob must already
+	// find it or that report is wrongly rejected as unknown.
+	r.mu.Lock()
+	r.inFlight[rj.job.ID] = rj
+	r.mu.Unlock()
+
+	select {
+	case ch <- rj:
+	default:
+		r.forget(rj.job.ID)
+		return nil, errors.New("configurer daemon for " + serviceName + " is backed up, not accepting new jobs")
+	}
+	return rj, nil
+}
+
+// forget removes jobID from inFlight, so a job that Configure gave up waiting on (via
+// configureJobTimeout) doesn't linger forever - a late PublishStatus/CompleteJob for it is then
+// rejected as unknown rather than silently accepted.
+func (r *RemoteConfigurerRegistry) forget(jobID string) {
+	r.mu.Lock()
+	delete(r.inFlight, jobID)
+	r.mu.Unlock()
+}
+
+// AcquireJob authenticates secret against serviceName and returns the next queued
+// ConfigurationJob for it, blocking up to acquireJobLongPollTimeout. A nil job with a nil error
+// means no work was queued in that window - the caller (a long-polling daemon) should simply call
+// again.
+func (r *RemoteConfigurerRegistry) AcquireJob(serviceName, secret string) (*ConfigurationJob, error) {
+	if !r.VerifySecret(serviceName, secret) {
+		return nil, errors.New("invalid secret for service " + serviceName)
+	}
+	r.mu.Lock()
+	ch := r.pending[serviceName]
+	r.mu.Unlock()
+
+	select {
+	case rj := <-ch:
+		return &rj.job, nil
+	case <-time.After(acquireJobLongPollTimeout):
+		return nil, nil
+	}
+}
+
+// PublishStatus authenticates secret against the service jobID was queued for and forwards
+// status/description to whatever is waiting on the job in Configure, so a UI watching via
+// StatusStreamHandler sees the daemon's intermediate progress rather than only a final outcome.
+func (r *RemoteConfigurerRegistry) PublishStatus(jobID, secret, status, description string) error {
+	rj, err := r.authenticatedJob(jobID, secret)
+	if err != nil {
+		return err
+	}
+	select {
+	case rj.status <- Status{Status: status, Description: description, Started: time.Now()}:
+	default:
+	}
+	return nil
+}
+
+// CompleteJob authenticates secret against the service jobID was queued for, records the outcome,
+// and releases the job so Configure's wait on it returns. A job can only be completed once; a
+// second CompleteJob for the same ID is rejected rather than silently overwriting the first
+// result.
+func (r *RemoteConfigurerRegistry) CompleteJob(jobID, secret string, deployment *dc.DeploymentConfig, daemonErr string) error {
+	rj, err := r.authenticatedJob(jobID, secret)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	delete(r.inFlight, jobID)
+	r.mu.Unlock()
+
+	result := jobResult{deployment: deployment}
+	if daemonErr != "" {
+		result.err = errors.New(daemonErr)
+	}
+	select {
+	case rj.done <- result:
+	default:
+		return errors.New("job " + jobID + " already completed")
+	}
+	return nil
+}
+
+// authenticatedJob looks up the in-flight job for jobID and verifies secret against the service it
+// belongs to.
+func (r *RemoteConfigurerRegistry) authenticatedJob(jobID, secret string) (*remoteJob, error) {
+	r.mu.Lock()
+	rj, ok := r.inFlight[jobID]
+	r.mu.Unlock()
+	if !ok {
+		return nil, errors.New("no in-flight job " + jobID)
+	}
+	if !r.VerifySecret(rj.job.ServiceName, secret) {
+		return nil, errors.New("invalid secret for job " + jobID)
+	}
+	return rj, nil
+}
+
+// remoteConfigurer proxies Configure calls for serviceName to whichever out-of-process daemon has
+// registered to handle it, by enqueueing a ConfigurationJob on registry and waiting for the daemon
+// to acquire and complete it. Status updates the daemon publishes while working are relayed to
+// StatusPublisher as they arrive, not just the final outcome.
+type remoteConfigurer struct {
+	registry        *RemoteConfigurerRegistry
+	serviceName     string
+	StatusPublisher StatusPublisher
+	statusKey       string
+	wait            *sync.WaitGroup
+	// dryRun is set by Factory from Configuration.DryRun. When true, Configure reports a
+	// synthetic "would configure" status instead of enqueueing a ConfigurationJob - an external
+	// daemon has no in-memory plan to build the way buildJob does for the in-tree Configurers, so
+	// there is nothing to hand back via PlannedJob either.
+	dryRun bool
+}
+
+// Configure enqueues a ConfigurationJob for r.serviceName and blocks until a daemon completes it
+// or configureJobTimeout elapses. In dry-run mode it does neither, since enqueueing would hand the
+// real Deployment to an external daemon that might act on it for real.
+func (r *remoteConfigurer) Configure(client Client, deployment *dc.DeploymentConfig, namespace string) (*dc.DeploymentConfig, error) {
+	r.wait.Add(1)
+	defer r.wait.Done()
+
+	if r.dryRun {
+		r.StatusPublisher.Publish(r.statusKey, configComplete, "dry run: would configure "+r.serviceName+" via remote configurer daemon")
+		return deployment, nil
+	}
+
+	rj, err := r.registry.enqueue(r.serviceName, namespace, deployment)
+	if err != nil {
+		r.StatusPublisher.Publish(r.statusKey, configError, err.Error())
+		return nil, err
+	}
+
+	r.StatusPublisher.Publish(r.statusKey, configInProgress, "queued for remote configurer daemon for "+r.serviceName)
+	deadline := time.After(configureJobTimeout)
+	for {
+		select {
+		case status := <-rj.status:
+			r.StatusPublisher.Publish(r.statusKey, status.Status, status.Description)
+		case result := <-rj.done:
+			if result.err != nil {
+				r.StatusPublisher.Publish(r.statusKey, configError, "remote configurer daemon failed: "+result.err.Error())
+				return nil, result.err
+			}
+			r.StatusPublisher.Publish(r.statusKey, configComplete, "remote configurer daemon finished configuring "+r.serviceName)
+			return result.deployment, nil
+		case <-deadline:
+			r.registry.forget(rj.job.ID)
+			err := errors.New("timed out waiting for remote configurer daemon to complete " + r.serviceName)
+			r.StatusPublisher.Publish(r.statusKey, configError, err.Error())
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package deploy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/feedhenry/negotiator/pkg/log"
+)
+
+// StatusBroadcaster wraps a StatusPublisher, additionally keeping a per-key history of published
+// Status entries and fanning each one out to subscribers. CacheRedisConfigure,
+// DataMongoConfigure and DataMysqlConfigure keep calling Publish exactly as before; wiring a
+// StatusBroadcaster in as the StatusPublisher is what lets a UI stream the intermediate
+// transitions instead of polling LastActionHandler for the last snapshot.
+type StatusBroadcaster struct {
+	StatusPublisher
+	mu          sync.Mutex
+	history     map[string][]Status
+	subscribers map[string]map[chan Status]struct{}
+	// HistoryLimit caps how many past entries are retained per key; 0 means unbounded.
+	HistoryLimit int
+}
+
+// NewStatusBroadcaster wraps publisher, retaining up to historyLimit past Status entries per key
+// for late subscribers (0 means unbounded).
+func NewStatusBroadcaster(publisher StatusPublisher, historyLimit int) *StatusBroadcaster {
+	return &StatusBroadcaster{
+		StatusPublisher: publisher,
+		history:         map[string][]Status{},
+		subscribers:     map[string]map[chan Status]struct{}{},
+		HistoryLimit:    historyLimit,
+	}
+}
+
+// NewBroadcastingEnvironmentServiceConfigController returns an EnvironmentServiceConfigController
+// whose StatusPublisher is a StatusBroadcaster wrapping publisher, and returns that broadcaster
+// alongside it so callers can hand it to NewStatusStreamHandler. Without this, wiring a
+// broadcaster in means reaching past the controller to replace its StatusPublisher by hand after
+// construction, which is easy to get wrong or forget.
+func NewBroadcastingEnvironmentServiceConfigController(configFactory ServiceConfigFactory, log log.Logger, publisher StatusPublisher, tl TemplateLoader, historyLimit int) (*EnvironmentServiceConfigController, *StatusBroadcaster) {
+	broadcaster := NewStatusBroadcaster(publisher, historyLimit)
+	return NewEnvironmentServiceConfigController(configFactory, log, broadcaster, tl), broadcaster
+}
+
+// Publish forwards to the wrapped StatusPublisher, then records and fans the update out to any
+// subscribers of key. A subscriber that isn't keeping up with its channel has the update dropped
+// rather than blocking the publisher.
+func (b *StatusBroadcaster) Publish(key string, status, description string) error {
+	err := b.StatusPublisher.Publish(key, status, description)
+
+	entry := Status{Status: status, Description: description, Started: time.Now()}
+	b.mu.Lock()
+	b.history[key] = append(b.history[key], entry)
+	if b.HistoryLimit > 0 && len(b.history[key]) > b.HistoryLimit {
+		b.history[key] = b.history[key][len(b.history[key])-b.HistoryLimit:]
+	}
+	subs := b.subscribers[key]
+	b.mu.Unlock()
+
+	for sub := range subs {
+		select {
+		case sub <- entry:
+		default:
+		}
+	}
+	return err
+}
+
+// StatusSubscription is a handle to a live feed of Status updates for a single key.
+type StatusSubscription struct {
+	Events <-chan Status
+	cancel func()
+}
+
+// Close releases the subscription.
+func (s *StatusSubscription) Close() {
+	s.cancel()
+}
+
+// Subscribe returns a StatusSubscription for key. When past is true every buffered history entry
+// for key is delivered before live updates, so a late-joining client sees the full backlog
+// rather than only whatever is published after it connects.
+func (b *StatusBroadcaster) Subscribe(key string, past bool) *StatusSubscription {
+	b.mu.Lock()
+	var backlog []Status
+	if past {
+		backlog = b.history[key]
+	}
+	// sized to hold the whole backlog and filled while b.mu is held, so no update published after
+	// this subscription exists can reach the channel ahead of it
+	ch := make(chan Status, len(backlog)+16)
+	for _, entry := range backlog {
+		ch <- entry
+	}
+	if b.subscribers[key] == nil {
+		b.subscribers[key] = map[chan Status]struct{}{}
+	}
+	b.subscribers[key][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return &StatusSubscription{
+		Events: ch,
+		cancel: func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers[key], ch)
+			if len(b.subscribers[key]) == 0 {
+				delete(b.subscribers, key)
+			}
+			close(ch)
+		},
+	}
+}
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/feedhenry/negotiator/pkg/deploy"
+	"github.com/feedhenry/negotiator/pkg/log"
+)
+
+// Previewer is the subset of EnvironmentServiceConfigController the PreviewHandler depends on, so
+// tests can supply a fake rather than a real OpenShift client.
+type Previewer interface {
+	Preview(client deploy.Client, config *deploy.Configuration) (*deploy.ConfigurationPlan, error)
+}
+
+// PreviewHandler serves a dry run of configuration, returning the ConfigurationPlan a real
+// Configure call would produce without creating any Job or persisting the DeploymentConfig.
+type PreviewHandler struct {
+	previewer Previewer
+	client    deploy.Client
+	logger    log.Logger
+}
+
+// NewPreviewHandler returns a PreviewHandler backed by previewer, issuing requests against client.
+func NewPreviewHandler(previewer Previewer, client deploy.Client, logger log.Logger) PreviewHandler {
+	return PreviewHandler{previewer: previewer, client: client, logger: logger}
+}
+
+// Preview handles POST /deploy/preview. The request body is decoded directly into a
+// deploy.Configuration - DryRun is forced on regardless of what the caller sends.
+func (h PreviewHandler) Preview(rw http.ResponseWriter, req *http.Request) {
+	var config deploy.Configuration
+	if err := json.NewDecoder(req.Body).Decode(&config); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte("invalid request body: " + err.Error()))
+		return
+	}
+
+	plan, err := h.previewer.Preview(h.client, &config)
+	if err != nil {
+		h.logger.Error("failed to build configuration preview ", err.Error())
+		rw.WriteHeader(http.StatusInternalServerError)
+		rw.Write([]byte("failed to build preview: " + err.Error()))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(plan); err != nil {
+		h.logger.Error("failed to encode configuration plan ", err.Error())
+	}
+}
@@ -0,0 +1,137 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/feedhenry/negotiator/pkg/deploy"
+	"github.com/feedhenry/negotiator/pkg/log"
+	dc "github.com/openshift/origin/pkg/deploy/api"
+	"github.com/gorilla/mux"
+)
+
+// RemoteConfigurerHandler exposes a *deploy.RemoteConfigurerRegistry over HTTP, so a
+// ConfigurerDaemon can run as an independent process: register the services it handles,
+// long-poll for work, and report progress and completion, all without linking against this
+// binary.
+type RemoteConfigurerHandler struct {
+	registry *deploy.RemoteConfigurerRegistry
+	logger   log.Logger
+}
+
+// NewRemoteConfigurerHandler returns a RemoteConfigurerHandler backed by registry.
+func NewRemoteConfigurerHandler(registry *deploy.RemoteConfigurerRegistry, logger log.Logger) RemoteConfigurerHandler {
+	return RemoteConfigurerHandler{registry: registry, logger: logger}
+}
+
+// registerRequest is the body of POST /configurers/register.
+type registerRequest struct {
+	ServiceName string   `json:"serviceName"`
+	Secret      string   `json:"secret"`
+	Tags        []string `json:"tags"`
+}
+
+// Register handles POST /configurers/register, recording that a daemon identified by secret now
+// handles serviceName.
+func (h RemoteConfigurerHandler) Register(rw http.ResponseWriter, req *http.Request) {
+	var body registerRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(rw, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.ServiceName == "" || body.Secret == "" {
+		http.Error(rw, "serviceName and secret are required", http.StatusBadRequest)
+		return
+	}
+	h.registry.Register(body.ServiceName, body.Secret, body.Tags)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// acquireRequest is the body of POST /configurers/{serviceName}/jobs/acquire.
+type acquireRequest struct {
+	Secret string `json:"secret"`
+}
+
+// AcquireJob handles POST /configurers/{serviceName}/jobs/acquire, long-polling for the next
+// deploy.ConfigurationJob queued for serviceName. It responds 204 with no body if the long-poll
+// window elapses without work - the daemon is expected to call again immediately. The secret
+// travels in the JSON body rather than the URL, like every other endpoint here, so it doesn't end
+// up in access or proxy logs.
+func (h RemoteConfigurerHandler) AcquireJob(rw http.ResponseWriter, req *http.Request) {
+	serviceName := mux.Vars(req)["serviceName"]
+	if serviceName == "" {
+		http.Error(rw, "serviceName is required", http.StatusBadRequest)
+		return
+	}
+	var body acquireRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(rw, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.registry.AcquireJob(serviceName, body.Secret)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if job == nil {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(job)
+}
+
+// statusRequest is the body of POST /configurers/{serviceName}/jobs/{jobID}/status.
+type statusRequest struct {
+	Secret      string `json:"secret"`
+	Status      string `json:"status"`
+	Description string `json:"description"`
+}
+
+// PublishStatus handles POST /configurers/{serviceName}/jobs/{jobID}/status, relaying a daemon's
+// in-progress status for jobID back to whatever is waiting on it.
+func (h RemoteConfigurerHandler) PublishStatus(rw http.ResponseWriter, req *http.Request) {
+	jobID := mux.Vars(req)["jobID"]
+	if jobID == "" {
+		http.Error(rw, "jobID is required", http.StatusBadRequest)
+		return
+	}
+	var body statusRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(rw, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.registry.PublishStatus(jobID, body.Secret, body.Status, body.Description); err != nil {
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// completeRequest is the body of POST /configurers/{serviceName}/jobs/{jobID}/complete.
+type completeRequest struct {
+	Secret     string               `json:"secret"`
+	Deployment *dc.DeploymentConfig `json:"deployment"`
+	Error      string               `json:"error"`
+}
+
+// CompleteJob handles POST /configurers/{serviceName}/jobs/{jobID}/complete, recording jobID's
+// outcome so the Configure call waiting on it returns.
+func (h RemoteConfigurerHandler) CompleteJob(rw http.ResponseWriter, req *http.Request) {
+	jobID := mux.Vars(req)["jobID"]
+	if jobID == "" {
+		http.Error(rw, "jobID is required", http.StatusBadRequest)
+		return
+	}
+	var body completeRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(rw, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.registry.CompleteJob(jobID, body.Secret, body.Deployment, body.Error); err != nil {
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
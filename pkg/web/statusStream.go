@@ -0,0 +1,75 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/feedhenry/negotiator/pkg/deploy"
+	"github.com/feedhenry/negotiator/pkg/log"
+	"github.com/gorilla/mux"
+)
+
+// StatusStreamer lets a handler subscribe to live Status updates for a statusKey, as produced by
+// a deploy.StatusBroadcaster.
+type StatusStreamer interface {
+	Subscribe(key string, past bool) *deploy.StatusSubscription
+}
+
+// StatusStreamHandler streams every Status update for an instance/operation as it happens, via
+// Server-Sent Events, so a UI doesn't have to poll LastActionHandler to catch the intermediate
+// "starting configuration…", "configuration job succeeded" transitions.
+type StatusStreamHandler struct {
+	streamer StatusStreamer
+	logger   log.Logger
+}
+
+// NewStatusStreamHandler returns a StatusStreamHandler backed by streamer.
+func NewStatusStreamHandler(streamer StatusStreamer, logger log.Logger) StatusStreamHandler {
+	return StatusStreamHandler{streamer: streamer, logger: logger}
+}
+
+// Events handles GET /instances/{instance_id}/{operation}/events, writing a JSON Status frame
+// for every update and terminating the stream once a terminal status is reached or the request
+// is cancelled.
+func (h StatusStreamHandler) Events(rw http.ResponseWriter, req *http.Request) {
+	params := mux.Vars(req)
+	statusKey := deploy.StatusKey(params["instance_id"], params["operation"])
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := h.streamer.Subscribe(statusKey, true)
+	defer sub.Close()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(rw)
+	for {
+		select {
+		case status, ok := <-sub.Events:
+			if !ok {
+				return
+			}
+			rw.Write([]byte("data: "))
+			if err := encoder.Encode(status); err != nil {
+				h.logger.Error("failed to encode status event ", err.Error())
+				return
+			}
+			rw.Write([]byte("\n"))
+			flusher.Flush()
+			// mirrors the configComplete/configError values a Configurer publishes
+			if status.Status == "succeeded" || status.Status == "failed" {
+				return
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package web
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/feedhenry/negotiator/pkg/deploy"
+	"github.com/gorilla/mux"
+)
+
+// fakeLogger is a no-op log.Logger for tests that don't care about log output.
+type fakeLogger struct{}
+
+func (fakeLogger) Info(args ...interface{})  {}
+func (fakeLogger) Error(args ...interface{}) {}
+
+func TestStatusStreamHandlerEventsUsesTwoPartStatusKey(t *testing.T) {
+	broadcaster := deploy.NewStatusBroadcaster(deploy.LogStatusPublisher{Logger: fakeLogger{}}, 0)
+	// Publish under the key every Configurer actually publishes to (instance_id:operation), before
+	// Subscribe-ing, so the "replay past history" path exercises the same key lookup a live update
+	// would. If Events built its statusKey with the old, copy-pasted "noplan" segment, Subscribe
+	// would be listening on a key this Publish never reaches, and this entry would never arrive.
+	if err := broadcaster.Publish(deploy.StatusKey("instance-1", "configure"), "succeeded", "all done"); err != nil {
+		t.Fatalf("unexpected error publishing status: %s", err)
+	}
+
+	handler := NewStatusStreamHandler(broadcaster, fakeLogger{})
+
+	req := httptest.NewRequest("GET", "/instances/instance-1/configure/events", nil)
+	req = mux.SetURLVars(req, map[string]string{"instance_id": "instance-1", "operation": "configure"})
+	rw := httptest.NewRecorder()
+
+	handler.Events(rw, req)
+
+	body := rw.Body.String()
+	if !strings.Contains(body, `"status":"succeeded"`) {
+		t.Errorf("expected the replayed status to be streamed to the client, got body: %q", body)
+	}
+}
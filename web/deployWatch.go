@@ -0,0 +1,113 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/feedhenry/negotiator/pkg/deploy"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// idleSubscriberTimeout bounds how long a watch connection is kept open without the broker
+// publishing a new status, so a client that goes away doesn't pin the connection open forever.
+const idleSubscriberTimeout = 10 * time.Minute
+
+var deployWatchUpgrader = websocket.Upgrader{
+	// the deploy API is consumed by CI pipelines and tooling from a variety of hosts, not a
+	// single browser origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// DeployWatchHandler streams deploy.DeploymentStatus updates for a single dispatched deploy,
+// sourced from a deploy.StatusBroker, in place of a client polling its WatchURL directly.
+type DeployWatchHandler struct {
+	broker *deploy.StatusBroker
+	logger Logger
+}
+
+// NewDeployWatchHandler returns a DeployWatchHandler backed by broker.
+func NewDeployWatchHandler(broker *deploy.StatusBroker, logger Logger) DeployWatchHandler {
+	return DeployWatchHandler{broker: broker, logger: logger}
+}
+
+// Watch handles GET /deploy/{nameSpace}/{template}/watch/{deployID}, upgrading to a WebSocket by
+// default or falling back to Server-Sent Events when the client sends Accept: text/event-stream.
+func (h DeployWatchHandler) Watch(rw http.ResponseWriter, req *http.Request) {
+	deployID := mux.Vars(req)["deployID"]
+	sub := h.broker.Subscribe(deployID, true)
+	defer sub.Close()
+
+	if req.Header.Get("Accept") == "text/event-stream" {
+		h.serveSSE(rw, req, sub)
+		return
+	}
+	h.serveWebSocket(rw, req, sub)
+}
+
+func (h DeployWatchHandler) serveWebSocket(rw http.ResponseWriter, req *http.Request, sub *deploy.Subscription) {
+	conn, err := deployWatchUpgrader.Upgrade(rw, req, nil)
+	if err != nil {
+		h.logger.Error("failed to upgrade deploy watch connection ", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				return
+			}
+			if err := conn.WriteJSON(event.Status); err != nil {
+				return
+			}
+			if isTerminalState(event.Status) {
+				return
+			}
+		case <-time.After(idleSubscriberTimeout):
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (h DeployWatchHandler) serveSSE(rw http.ResponseWriter, req *http.Request, sub *deploy.Subscription) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				return
+			}
+			rw.Write([]byte("data: "))
+			json.NewEncoder(rw).Encode(event.Status)
+			rw.Write([]byte("\n"))
+			flusher.Flush()
+			if isTerminalState(event.Status) {
+				return
+			}
+		case <-time.After(idleSubscriberTimeout):
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// isTerminalState reports whether status is a state the watch stream should close on.
+func isTerminalState(status deploy.DeploymentStatus) bool {
+	return status.State == deploy.DeploymentSucceeded || status.State == deploy.DeploymentFailed
+}
@@ -1,13 +1,22 @@
 package web
 
 import (
+	"crypto/subtle"
+	"crypto/tls"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/negroni"
 	"github.com/feedhenry/negotiator/deploy"
+	pkgdeploy "github.com/feedhenry/negotiator/pkg/deploy"
+	"github.com/feedhenry/negotiator/pkg/log"
+	pkgweb "github.com/feedhenry/negotiator/pkg/web"
 	"github.com/gorilla/mux"
+	"github.com/phyber/negroni-gzip/gzip"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // BuildRouter is the main place we build the mux router
@@ -16,25 +25,96 @@ func BuildRouter() *mux.Router {
 	return r
 }
 
+// MiddlewareOptions configures the optional middleware BuildHTTPHandler adds on top of the
+// baseline recovery/correlation-id/auth stack.
+type MiddlewareOptions struct {
+	// Gzip enables gzip compression of responses.
+	Gzip bool
+	// AccessLog enables a structured JSON access log line per request, emitted through the
+	// existing logrus logger and tagged with the request's correlation ID.
+	AccessLog bool
+	// RequestTimeout, if non-zero, aborts a request that runs longer than this with a 503 rather
+	// than letting it run indefinitely.
+	RequestTimeout time.Duration
+}
+
 // BuildHTTPHandler constructs a http.Handler it is also where common middleware is added via negroni
-func BuildHTTPHandler(r *mux.Router) http.Handler {
+func BuildHTTPHandler(r *mux.Router, opts MiddlewareOptions) http.Handler {
 	//recovery middleware for any panics in the handlers
 	recovery := negroni.NewRecovery()
 	recovery.PrintStack = false
 	//add middleware for all routes
 	n := negroni.New(recovery)
 	n.UseFunc(CorrellationID)
+	if opts.Gzip {
+		n.Use(gzip.Gzip(gzip.DefaultCompression))
+	}
+	if opts.AccessLog {
+		n.UseFunc(accessLog(logrus.StandardLogger()))
+	}
 	auth := Auth{logger: logrus.StandardLogger()}
 	n.UseFunc(auth.Auth)
 	// set up sys routes
 	n.UseHandler(r)
-	return n
+	var handler http.Handler = n
+	if opts.RequestTimeout > 0 {
+		handler = http.TimeoutHandler(n, opts.RequestTimeout, "request timed out")
+	}
+	return handler
 }
 
-// DeployRoute sets up the deploy route
-func DeployRoute(r *mux.Router, logger Logger, controller *deploy.Controller, clientFactory DeployClientFactory) {
+// accessLog returns a negroni middleware that emits one structured log line per request via
+// logger, including the correlation ID set by CorrellationID so access logs can be joined with
+// application logs for the same request.
+func accessLog(logger *logrus.Logger) negroni.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		start := time.Now()
+		next(rw, req)
+		fields := logrus.Fields{
+			"method":         req.Method,
+			"path":           req.URL.Path,
+			"duration_ms":    time.Since(start) / time.Millisecond,
+			"correlation_id": req.Header.Get("X-Correlation-Id"),
+		}
+		if nrw, ok := rw.(negroni.ResponseWriter); ok {
+			fields["status"] = nrw.Status()
+		}
+		logger.WithFields(fields).Info("request complete")
+	}
+}
+
+// DeployRoute sets up the deploy route, along with the status-watch endpoint that lets callers
+// stream a dispatched deploy's progress from broker instead of polling its WatchURL.
+func DeployRoute(r *mux.Router, logger Logger, controller *deploy.Controller, clientFactory DeployClientFactory, broker *pkgdeploy.StatusBroker) {
 	deployHandler := NewDeployHandler(logger, controller, clientFactory)
 	r.HandleFunc("/deploy/{nameSpace}/{template}", prometheus.InstrumentHandlerFunc("deploy", deployHandler.Deploy)).Methods("POST")
+
+	watchHandler := NewDeployWatchHandler(broker, logger)
+	r.HandleFunc("/deploy/{nameSpace}/{template}/watch/{deployID}", prometheus.InstrumentHandlerFunc("deployWatch", watchHandler.Watch)).Methods("GET")
+}
+
+// StatusStreamRoute registers the SSE endpoint that streams an instance's configuration Status
+// updates as they happen, backed by streamer (a *pkgdeploy.StatusBroadcaster).
+func StatusStreamRoute(r *mux.Router, logger log.Logger, streamer pkgweb.StatusStreamer) {
+	streamHandler := pkgweb.NewStatusStreamHandler(streamer, logger)
+	r.HandleFunc("/instances/{instance_id}/{operation}/events", prometheus.InstrumentHandlerFunc("statusStream", streamHandler.Events)).Methods("GET")
+}
+
+// PreviewRoute registers the dry-run configuration preview endpoint.
+func PreviewRoute(r *mux.Router, previewer pkgweb.Previewer, client pkgdeploy.Client, logger log.Logger) {
+	previewHandler := pkgweb.NewPreviewHandler(previewer, client, logger)
+	r.HandleFunc("/deploy/preview", prometheus.InstrumentHandlerFunc("deployPreview", previewHandler.Preview)).Methods("POST")
+}
+
+// RemoteConfigurerRoute registers the endpoints an out-of-process ConfigurerDaemon uses to
+// register the services it handles, long-poll for queued ConfigurationJobs, and report progress
+// and completion back, via registry.
+func RemoteConfigurerRoute(r *mux.Router, registry *pkgdeploy.RemoteConfigurerRegistry, logger log.Logger) {
+	remoteConfigurerHandler := pkgweb.NewRemoteConfigurerHandler(registry, logger)
+	r.HandleFunc("/configurers/register", prometheus.InstrumentHandlerFunc("configurerRegister", remoteConfigurerHandler.Register)).Methods("POST")
+	r.HandleFunc("/configurers/{serviceName}/jobs/acquire", prometheus.InstrumentHandlerFunc("configurerAcquireJob", remoteConfigurerHandler.AcquireJob)).Methods("POST")
+	r.HandleFunc("/configurers/{serviceName}/jobs/{jobID}/status", prometheus.InstrumentHandlerFunc("configurerPublishStatus", remoteConfigurerHandler.PublishStatus)).Methods("POST")
+	r.HandleFunc("/configurers/{serviceName}/jobs/{jobID}/complete", prometheus.InstrumentHandlerFunc("configurerCompleteJob", remoteConfigurerHandler.CompleteJob)).Methods("POST")
 }
 
 // SysRoute sets up the sys routes
@@ -44,7 +124,57 @@ func SysRoute(r *mux.Router) {
 	r.HandleFunc("/sys/info/health", prometheus.InstrumentHandlerFunc("health", sysHandler.Health)).Methods("GET")
 }
 
-// Metrics route
+// Metrics route. prometheus.Handler() is deprecated in favour of promhttp, which also avoids
+// registering collectors against the global DefaultGatherer implicitly.
 func Metrics(r *mux.Router) {
-	r.Handle("/metrics", prometheus.Handler()).Methods("GET")
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+}
+
+// MetricsOptions configures how the /metrics endpoint is exposed when served on its own listener
+// via ServeMetrics, rather than alongside the deploy API on r.
+type MetricsOptions struct {
+	// ListenAddr is the address ServeMetrics binds to, e.g. ":9090".
+	ListenAddr string
+	// BearerToken, if set, is required via the Authorization header before scrapes are served.
+	BearerToken string
+	// TLSConfig, if set, protects the listener with mTLS (set ClientAuth/ClientCAs to require
+	// client certificates).
+	TLSConfig *tls.Config
+}
+
+// ServeMetrics starts a dedicated listener exposing /metrics so cluster scrape credentials
+// aren't exposed on the same socket as the deploy API. It blocks until the listener fails or is
+// closed, so callers should run it in its own goroutine.
+func ServeMetrics(opts MetricsOptions) error {
+	handler := promhttp.Handler()
+	if opts.BearerToken != "" {
+		handler = requireBearerToken(opts.BearerToken, handler)
+	}
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", handler)
+
+	server := &http.Server{
+		Addr:      opts.ListenAddr,
+		Handler:   metricsMux,
+		TLSConfig: opts.TLSConfig,
+	}
+	if opts.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// requireBearerToken wraps handler so requests must present the configured bearer token via the
+// Authorization header, for protecting a metrics listener that isn't behind mTLS.
+func requireBearerToken(token string, handler http.Handler) http.Handler {
+	const prefix = "Bearer "
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) ||
+			subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(token)) != 1 {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(rw, req)
+	})
 }
\ No newline at end of file
@@ -0,0 +1,73 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// GracefulServer runs an http.Server and, on SIGINT/SIGTERM, stops accepting new connections and
+// waits for both in-flight HTTP requests and any longer-running work a handler kicked off (such
+// as the DependencyWaiter goroutines a deploy starts) to finish before the process exits.
+type GracefulServer struct {
+	Server *http.Server
+	Logger *logrus.Logger
+	// ShutdownTimeout bounds how long Shutdown waits for requests and InFlight to drain before
+	// giving up.
+	ShutdownTimeout time.Duration
+	// InFlight, if set, is incremented by a handler when it starts work that should block
+	// shutdown and decremented when that work finishes.
+	InFlight *sync.WaitGroup
+}
+
+// ListenAndServe runs the server until it errors or a SIGINT/SIGTERM is received, in which case
+// it shuts down gracefully and returns nil, or Shutdown's error if in-flight requests have not
+// drained within ShutdownTimeout.
+func (g *GracefulServer) ListenAndServe() error {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- g.Server.ListenAndServe()
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case err := <-serverErr:
+		return err
+	case s := <-sig:
+		g.Logger.Info("received signal, shutting down gracefully ", s.String())
+		ctx, cancel := context.WithTimeout(context.Background(), g.ShutdownTimeout)
+		defer cancel()
+		if err := g.Server.Shutdown(ctx); err != nil {
+			return err
+		}
+		g.waitForInFlight(ctx)
+		return nil
+	}
+}
+
+// waitForInFlight waits for InFlight to drain or ctx to expire, whichever happens first, logging
+// a warning if work was still outstanding at the deadline.
+func (g *GracefulServer) waitForInFlight(ctx context.Context) {
+	if g.InFlight == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		g.InFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		g.Logger.Warn("timed out waiting for in-flight deploys to drain")
+	}
+}